@@ -15,7 +15,24 @@ func main() {
 	}
 	Log.Info("agent started")
 
-	tel := telemetry.NewTelemetry(config.ServerAddress, config.HashKey, config.RateLimit, Log)
+	collectors := telemetry.NewRegistry()
+	for _, c := range []telemetry.Collector{
+		telemetry.NewRuntimeCollector(),
+		telemetry.NewPsutilCollector(),
+	} {
+		if err := collectors.Register(c); err != nil {
+			panic(err)
+		}
+	}
+	if err := collectors.Enable(config.Collectors...); err != nil {
+		panic(err)
+	}
+	Log.Infof("Enabled collectors: %d", len(collectors.Collectors()))
+
+	tel, err := telemetry.NewTelemetry(config, collectors, Log)
+	if err != nil {
+		panic(err)
+	}
 
 	err = tel.Run(config.PollInterval, config.ReportInterval)
 
@@ -5,12 +5,18 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/Mr-Punder/go-alerting-service/internal/alerting"
+	"github.com/Mr-Punder/go-alerting-service/internal/graphite"
+	"github.com/Mr-Punder/go-alerting-service/internal/grpcserver"
 	"github.com/Mr-Punder/go-alerting-service/internal/handlers"
 	"github.com/Mr-Punder/go-alerting-service/internal/logger"
 	"github.com/Mr-Punder/go-alerting-service/internal/metricserver"
 	"github.com/Mr-Punder/go-alerting-service/internal/middleware"
+	"github.com/Mr-Punder/go-alerting-service/internal/notifier"
 	"github.com/Mr-Punder/go-alerting-service/internal/server/config"
+	"github.com/Mr-Punder/go-alerting-service/internal/statsd"
 	"github.com/Mr-Punder/go-alerting-service/internal/storage"
 )
 
@@ -36,7 +42,34 @@ func main() {
 		log.Info("Storage is closed")
 	}()
 
-	router := handlers.NewMetricRouter(stor, log)
+	rules := make([]alerting.Rule, 0)
+	if conf.RulesFile != "" {
+		rules, err = alerting.LoadRules(conf.RulesFile)
+		if err != nil {
+			log.Errorf("Error loading alerting rules %s", err)
+			panic(err)
+		}
+		log.Infof("Loaded %d alerting rules from %s", len(rules), conf.RulesFile)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var notif alerting.Notifier
+	if len(conf.Webhooks) > 0 {
+		webhooks := notifier.NewWebhookNotifier(conf.Webhooks, conf.HashKey, log)
+		go webhooks.Run(ctx)
+		notif = webhooks
+		log.Infof("Alert notifications will be sent to %v", conf.Webhooks)
+	}
+
+	engine := alerting.NewEngine(rules, stor, notif, log)
+	if len(rules) > 0 && conf.AlertInterval > 0 {
+		go engine.Run(ctx, time.Duration(conf.AlertInterval)*time.Second)
+		log.Info("Started alerting engine")
+	}
+
+	router := handlers.NewMetricRouter(stor, engine, log)
 	mserver := metricserver.NewMetricServer(conf.FlagRunAddr, router, log)
 
 	comp := middleware.NewGzipCompressor(log)
@@ -52,13 +85,72 @@ func main() {
 
 	go mserver.RunServer()
 
+	var grpcServer *grpcserver.Server
+	if conf.GRPCAddress != "" {
+		grpcServer, err = grpcserver.NewServer(conf.GRPCAddress, stor, conf.HashKey, log)
+		if err != nil {
+			log.Errorf("Error starting grpc server %s", err)
+			panic(err)
+		}
+		go grpcServer.RunServer()
+	}
+
+	var statsdServer *statsd.Server
+	if conf.StatsdAddress != "" && conf.StatsdFlush > 0 {
+		statsdServer, err = statsd.NewServer(conf.StatsdAddress, time.Duration(conf.StatsdFlush)*time.Second, stor, log)
+		if err != nil {
+			log.Errorf("Error starting statsd listener %s", err)
+			panic(err)
+		}
+		go statsdServer.RunServer()
+	}
+
+	var graphiteServer *graphite.Server
+	if conf.GraphiteAddress != "" {
+		templates, err := graphite.ParseTemplates(conf.GraphiteTemplate)
+		if err != nil {
+			log.Errorf("Error parsing graphite templates %s", err)
+			panic(err)
+		}
+		graphiteServer, err = graphite.NewServer(conf.GraphiteAddress, templates, int(conf.GraphiteConns), stor, log)
+		if err != nil {
+			log.Errorf("Error starting graphite listener %s", err)
+			panic(err)
+		}
+		go graphiteServer.RunServer()
+	}
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
 
 	log.Info("Initialized shutdown")
+	cancel()
+	if notifier, ok := stor.(storage.MetricsNotifier); ok {
+		// event streams never end by themselves
+		notifier.StopNotifications()
+	}
 	if err := mserver.Shutdown(context.Background()); err != nil {
 		log.Errorf("Cann't stop server %s", err)
 	}
+	if grpcServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := grpcServer.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Cann't stop grpc server %s", err)
+		}
+		shutdownCancel()
+	}
+	if graphiteServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := graphiteServer.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Cann't stop graphite listener %s", err)
+		}
+		shutdownCancel()
+	}
+	if statsdServer != nil {
+		if err := statsdServer.Shutdown(context.Background()); err != nil {
+			log.Errorf("Cann't stop statsd listener %s", err)
+		}
+	}
 
 }
@@ -5,24 +5,31 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	PollInterval   time.Duration
-	ReportInterval time.Duration
-	ServerAddress  string
-	LogLevel       string
-	LogOutputPath  string
-	LogErrorPath   string
-	HashKey        string
-	RateLimit      int
+	PollInterval     time.Duration
+	ReportInterval   time.Duration
+	ServerAddress    string
+	LogLevel         string
+	LogOutputPath    string
+	LogErrorPath     string
+	HashKey          string
+	RateLimit        int
+	Collectors       []string
+	GaugeAggregation string
+	SpoolDir         string
+	SpoolSize        int64
+	GRPCAddress      string
 }
 
 func New() Config {
 	var (
-		rawPollInterval, rawReportInterval, rawRateLimit                          int
-		rawServerAddress, rawlogLevel, rawlogOutputPath, rawlogErrortPath, rawKey string
+		rawPollInterval, rawReportInterval, rawRateLimit                                                                                           int
+		rawServerAddress, rawlogLevel, rawlogOutputPath, rawlogErrortPath, rawKey, rawCollectors, rawGaugeAggregation, rawSpoolDir, rawGRPCAddress string
+		rawSpoolSize                                                                                                                               int64
 	)
 	flag.StringVar(&rawServerAddress, "a", "localhost:8080", "address and port to connect")
 	flag.IntVar(&rawPollInterval, "r", 2, "poll interval")
@@ -32,6 +39,11 @@ func New() Config {
 	flag.StringVar(&rawlogOutputPath, "lp", "stdout", "log output path")
 	flag.StringVar(&rawlogErrortPath, "le", "stderr", "log error output path")
 	flag.StringVar(&rawKey, "k", "", "Key for hash summ")
+	flag.StringVar(&rawCollectors, "c", "", "comma separated enabled collectors, all if empty")
+	flag.StringVar(&rawGaugeAggregation, "ga", "last", "gauge aggregation between reports: last, min, max or avg")
+	flag.StringVar(&rawSpoolDir, "sd", "", "directory for unsent batches, spool is disabled if empty")
+	flag.Int64Var(&rawSpoolSize, "ss", 10<<20, "spool size cap in bytes")
+	flag.StringVar(&rawGRPCAddress, "g", "", "grpc server address, metrics are sent over grpc instead of http if set")
 
 	flag.Parse()
 
@@ -74,21 +86,52 @@ func New() Config {
 		rawKey = envHashKey
 	}
 	if envRateLimit, ok := os.LookupEnv("RATE_LIMIT"); ok {
-		rawRateLimit, err := strconv.ParseInt(envRateLimit, 10, 64)
+		rawRateLimit64, err := strconv.ParseInt(envRateLimit, 10, 64)
 		if err != nil {
-			log.Fatal("wrong report interval")
+			log.Fatal("wrong rate limit")
+		}
+		rawRateLimit = int(rawRateLimit64)
+	}
+
+	if envCollectors, ok := os.LookupEnv("COLLECTORS"); ok {
+		rawCollectors = envCollectors
+	}
+	if envGaugeAggregation, ok := os.LookupEnv("GAUGE_AGGREGATION"); ok {
+		rawGaugeAggregation = envGaugeAggregation
+	}
+	if envSpoolDir, ok := os.LookupEnv("SPOOL_DIR"); ok {
+		rawSpoolDir = envSpoolDir
+	}
+	if envSpoolSize, ok := os.LookupEnv("SPOOL_SIZE"); ok {
+		spoolSize, err := strconv.ParseInt(envSpoolSize, 10, 64)
+		if err != nil {
+			log.Fatal("wrong spool size")
+		}
+		rawSpoolSize = spoolSize
+	}
+	if envGRPCAddress, ok := os.LookupEnv("GRPC_ADDRESS"); ok {
+		rawGRPCAddress = envGRPCAddress
+	}
+	collectors := make([]string, 0)
+	for _, name := range strings.Split(rawCollectors, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			collectors = append(collectors, name)
 		}
-		rawReportInterval = int(rawRateLimit)
 	}
 
 	return Config{
-		ServerAddress:  rawServerAddress,
-		PollInterval:   time.Duration(rawPollInterval) * time.Second,
-		ReportInterval: time.Duration(rawReportInterval) * time.Second,
-		LogLevel:       rawlogLevel,
-		LogOutputPath:  rawlogOutputPath,
-		LogErrorPath:   rawlogErrortPath,
-		HashKey:        rawKey,
-		RateLimit:      rawRateLimit,
+		ServerAddress:    rawServerAddress,
+		PollInterval:     time.Duration(rawPollInterval) * time.Second,
+		ReportInterval:   time.Duration(rawReportInterval) * time.Second,
+		LogLevel:         rawlogLevel,
+		LogOutputPath:    rawlogOutputPath,
+		LogErrorPath:     rawlogErrortPath,
+		HashKey:          rawKey,
+		RateLimit:        rawRateLimit,
+		Collectors:       collectors,
+		GaugeAggregation: rawGaugeAggregation,
+		SpoolDir:         rawSpoolDir,
+		SpoolSize:        rawSpoolSize,
+		GRPCAddress:      rawGRPCAddress,
 	}
 }
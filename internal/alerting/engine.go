@@ -0,0 +1,172 @@
+package alerting
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+)
+
+// State is a state of alert rule
+type State string
+
+const (
+	StateInactive State = "inactive"
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// Alert is current state of one rule
+type Alert struct {
+	Rule        string     `json:"rule"`
+	MetricID    string     `json:"metric"`
+	MType       string     `json:"type"`
+	State       State      `json:"state"`
+	Value       *float64   `json:"value,omitempty"`
+	ActiveSince *time.Time `json:"active_since,omitempty"`
+	FiredAt     *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Notifier is told about alerts that became firing or resolved
+type Notifier interface {
+	Notify(alert Alert)
+}
+
+// Engine periodically evaluates rules against metrics storage
+type Engine struct {
+	rules    []Rule
+	alerts   []Alert
+	stor     storage.MetricsGetter
+	notifier Notifier
+	log      logger.Logger
+	timeout  time.Duration
+	mu       sync.RWMutex
+}
+
+func NewEngine(rules []Rule, stor storage.MetricsGetter, notifier Notifier, log logger.Logger) *Engine {
+	alerts := make([]Alert, len(rules))
+	for i, rule := range rules {
+		alerts[i] = Alert{
+			Rule:     rule.Expr,
+			MetricID: rule.MetricID,
+			MType:    rule.MType,
+			State:    StateInactive,
+		}
+	}
+	return &Engine{
+		rules:    rules,
+		alerts:   alerts,
+		stor:     stor,
+		notifier: notifier,
+		log:      log,
+		timeout:  3 * time.Second,
+	}
+}
+
+// Run evaluates rules every interval until ctx is done
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			evalCtx, cancel := context.WithTimeout(ctx, e.timeout)
+			e.Evaluate(evalCtx, now)
+			cancel()
+		}
+	}
+}
+
+// Evaluate checks all rules once and moves alerts between states
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, rule := range e.rules {
+		alert := &e.alerts[i]
+
+		active := false
+		alert.Value = nil
+		metric, ok := e.stor.Get(ctx, metrics.Metrics{ID: rule.MetricID, MType: rule.MType})
+		if ok && metric.MType == rule.MType {
+			if value, ok := metricValue(metric); ok {
+				alert.Value = &value
+				active = rule.Check(value)
+			}
+		}
+
+		at := now
+		prev := alert.State
+		switch {
+		case active && (prev == StateInactive || prev == StateResolved):
+			alert.ActiveSince = &at
+			alert.FiredAt = nil
+			alert.ResolvedAt = nil
+			alert.State = StatePending
+			if rule.For == 0 {
+				alert.FiredAt = &at
+				alert.State = StateFiring
+			}
+		case active && prev == StatePending:
+			if now.Sub(*alert.ActiveSince) >= rule.For {
+				alert.FiredAt = &at
+				alert.State = StateFiring
+			}
+		case !active && prev == StatePending:
+			alert.ActiveSince = nil
+			alert.State = StateInactive
+		case !active && prev == StateFiring:
+			alert.ResolvedAt = &at
+			alert.State = StateResolved
+		}
+
+		if alert.State != prev {
+			e.log.Infof("Alert %q changed state %s -> %s", rule.Expr, prev, alert.State)
+			if e.notifier != nil && (alert.State == StateFiring || alert.State == StateResolved) {
+				e.notifier.Notify(*alert)
+			}
+		}
+	}
+}
+
+// Alerts returns copy of all alerts states
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	alerts := make([]Alert, len(e.alerts))
+	copy(alerts, e.alerts)
+	return alerts
+}
+
+// Firing returns alerts in firing state
+func (e *Engine) Firing() []Alert {
+	firing := make([]Alert, 0)
+	for _, alert := range e.Alerts() {
+		if alert.State == StateFiring {
+			firing = append(firing, alert)
+		}
+	}
+	return firing
+}
+
+func metricValue(metric metrics.Metrics) (float64, bool) {
+	switch metric.MType {
+	case "gauge":
+		if metric.Value != nil {
+			return *metric.Value, true
+		}
+	case "counter":
+		if metric.Delta != nil {
+			return float64(*metric.Delta), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,125 @@
+package alerting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    Rule
+		wantErr bool
+	}{
+		{
+			name: "gauge with unit and duration",
+			expr: "HeapAlloc gauge > 500MB for 2m",
+			want: Rule{
+				Expr:      "HeapAlloc gauge > 500MB for 2m",
+				MetricID:  "HeapAlloc",
+				MType:     "gauge",
+				Op:        ">",
+				Threshold: 500 * (1 << 20),
+				For:       2 * time.Minute,
+			},
+		},
+		{
+			name: "counter without duration",
+			expr: "PollCount counter >= 10",
+			want: Rule{
+				Expr:      "PollCount counter >= 10",
+				MetricID:  "PollCount",
+				MType:     "counter",
+				Op:        ">=",
+				Threshold: 10,
+			},
+		},
+		{
+			name:    "wrong type",
+			expr:    "HeapAlloc histogram > 5",
+			wantErr: true,
+		},
+		{
+			name:    "wrong operator",
+			expr:    "HeapAlloc gauge => 5",
+			wantErr: true,
+		},
+		{
+			name:    "wrong unit",
+			expr:    "HeapAlloc gauge > 5XB",
+			wantErr: true,
+		},
+		{
+			name:    "missing for",
+			expr:    "HeapAlloc gauge > 5 during 2m",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRule(tt.expr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rule)
+		})
+	}
+}
+
+func TestEngineEvaluate(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", log)
+	require.NoError(t, err)
+
+	rule, err := ParseRule("HeapAlloc gauge > 100 for 2m")
+	require.NoError(t, err)
+	engine := NewEngine([]Rule{rule}, stor, nil, log)
+
+	setValue := func(v float64) {
+		require.NoError(t, stor.Set(context.Background(), metrics.Metrics{ID: "HeapAlloc", MType: "gauge", Value: &v}))
+	}
+
+	start := time.Now()
+	steps := []struct {
+		name  string
+		value float64
+		after time.Duration
+		want  State
+	}{
+		{name: "below threshold", value: 50, after: 0, want: StateInactive},
+		{name: "crossed threshold", value: 150, after: time.Minute, want: StatePending},
+		{name: "still pending", value: 150, after: 2 * time.Minute, want: StatePending},
+		{name: "fired", value: 150, after: 3 * time.Minute, want: StateFiring},
+		{name: "resolved", value: 50, after: 4 * time.Minute, want: StateResolved},
+		{name: "pending again", value: 150, after: 5 * time.Minute, want: StatePending},
+		{name: "back to inactive", value: 50, after: 6 * time.Minute, want: StateInactive},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			setValue(step.value)
+			engine.Evaluate(context.Background(), start.Add(step.after))
+
+			alerts := engine.Alerts()
+			require.Len(t, alerts, 1)
+			assert.Equal(t, step.want, alerts[0].State)
+
+			if step.want == StateFiring {
+				assert.Len(t, engine.Firing(), 1)
+			} else {
+				assert.Empty(t, engine.Firing())
+			}
+		})
+	}
+}
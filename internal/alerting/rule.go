@@ -0,0 +1,137 @@
+package alerting
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule is a threshold condition on one metric, e.g. "HeapAlloc gauge > 500MB for 2m"
+type Rule struct {
+	Expr      string
+	MetricID  string
+	MType     string
+	Op        string
+	Threshold float64
+	For       time.Duration
+}
+
+var units = map[string]float64{
+	"":   1,
+	"K":  1 << 10,
+	"KB": 1 << 10,
+	"M":  1 << 20,
+	"MB": 1 << 20,
+	"G":  1 << 30,
+	"GB": 1 << 30,
+	"T":  1 << 40,
+	"TB": 1 << 40,
+}
+
+// ParseRule parses rule of form "<metric> <type> <op> <threshold>[unit] [for <duration>]"
+func ParseRule(expr string) (Rule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 4 && len(fields) != 6 {
+		return Rule{}, fmt.Errorf("rule %q: want \"<metric> <type> <op> <threshold> [for <duration>]\"", expr)
+	}
+
+	rule := Rule{
+		Expr:     strings.Join(fields, " "),
+		MetricID: fields[0],
+		MType:    fields[1],
+		Op:       fields[2],
+	}
+
+	if rule.MType != "gauge" && rule.MType != "counter" {
+		return Rule{}, fmt.Errorf("rule %q: wrong type %s", expr, rule.MType)
+	}
+
+	switch rule.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return Rule{}, fmt.Errorf("rule %q: wrong operator %s", expr, rule.Op)
+	}
+
+	threshold, err := parseThreshold(fields[3])
+	if err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %w", expr, err)
+	}
+	rule.Threshold = threshold
+
+	if len(fields) == 6 {
+		if fields[4] != "for" {
+			return Rule{}, fmt.Errorf("rule %q: expected \"for\", got %s", expr, fields[4])
+		}
+		rule.For, err = time.ParseDuration(fields[5])
+		if err != nil {
+			return Rule{}, fmt.Errorf("rule %q: %w", expr, err)
+		}
+	}
+
+	return rule, nil
+}
+
+func parseThreshold(raw string) (float64, error) {
+	i := len(raw)
+	for i > 0 && (raw[i-1] < '0' || raw[i-1] > '9') && raw[i-1] != '.' {
+		i--
+	}
+	mult, ok := units[strings.ToUpper(raw[i:])]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %s", raw[i:])
+	}
+	val, err := strconv.ParseFloat(raw[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong threshold %s", raw)
+	}
+	return val * mult, nil
+}
+
+// Check reports whether value satisfies rule condition
+func (r Rule) Check(value float64) bool {
+	switch r.Op {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	}
+	return false
+}
+
+// LoadRules reads rules from file, one per line. Empty lines and lines starting with # are skipped
+func LoadRules(path string) ([]Rule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := make([]Rule, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,125 @@
+package graphite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	templates, err := ParseTemplates("servers.* .host.measurement*; dc.*.*.cpu .dc.host.measurement.field; measurement.region")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantName   string
+		wantLabels map[string]string
+	}{
+		{
+			name:       "rest of path is name",
+			path:       "servers.web1.cpu.load",
+			wantName:   "cpu.load",
+			wantLabels: map[string]string{"host": "web1"},
+		},
+		{
+			name:       "second filter",
+			path:       "dc.eu.db1.cpu.idle",
+			wantName:   "cpu",
+			wantLabels: map[string]string{"dc": "eu", "host": "db1", "field": "idle"},
+		},
+		{
+			name:       "default template",
+			path:       "requests.us.ignored",
+			wantName:   "requests",
+			wantLabels: map[string]string{"region": "us"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, labels := Apply(templates, tt.path)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+
+	name, labels := Apply(nil, "path.to.metric")
+	assert.Equal(t, "path.to.metric", name)
+	assert.Nil(t, labels)
+
+	_, err = ParseTemplates("servers.* host.name")
+	assert.Error(t, err)
+}
+
+func TestServer(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+	templates, err := ParseTemplates("servers.* .host.measurement*")
+	require.NoError(t, err)
+
+	srv, err := NewServer("127.0.0.1:0", templates, 1, stor, Log)
+	require.NoError(t, err)
+	go srv.RunServer()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	fmt.Fprintf(conn, "path.to.metric 42 %d\nservers.web1.load 1.5 -1\nbroken\nnan.metric nan\n", time.Now().Unix())
+
+	ctx := context.Background()
+	require.Eventually(t, func() bool {
+		_, ok := stor.Get(ctx, metrics.Metrics{ID: "load", MType: "gauge", Labels: map[string]string{"host": "web1"}})
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	// connection above limit is closed
+	extra, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	extra.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = extra.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	extra.Close()
+
+	// connection sending too long line is closed, lines before it are stored
+	conn.Close()
+	require.Eventually(t, func() bool {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		return len(srv.conns) == 0
+	}, time.Second, 10*time.Millisecond)
+	long, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer long.Close()
+	fmt.Fprintf(long, "before.long 1\n%s", strings.Repeat("x", maxLineSize+1))
+	long.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = long.Read(make([]byte, 1))
+	// unread rest of line makes close a reset
+	var netErr net.Error
+	if assert.Error(t, err) && errors.As(err, &netErr) {
+		assert.False(t, netErr.Timeout())
+	}
+	_, ok := stor.Get(ctx, metrics.Metrics{ID: "before.long", MType: "gauge"})
+	assert.True(t, ok)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(shutdownCtx))
+
+	metric, ok := stor.Get(ctx, metrics.Metrics{ID: "path.to.metric", MType: "gauge"})
+	require.True(t, ok)
+	assert.Equal(t, 42.0, *metric.Value)
+	_, ok = stor.Get(ctx, metrics.Metrics{ID: "nan.metric", MType: "gauge"})
+	assert.False(t, ok)
+}
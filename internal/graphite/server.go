@@ -0,0 +1,192 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+)
+
+const (
+	// idleTimeout closes connections that send nothing
+	idleTimeout = time.Minute
+	// maxLineSize closes connections that send longer lines
+	maxLineSize = 16 * 1024
+)
+
+var errLineTooLong = fmt.Errorf("line is longer than %d bytes", maxLineSize)
+
+// Server receives Graphite plaintext lines "path value [timestamp]" over TCP and stores them as gauges
+type Server struct {
+	log       logger.Logger
+	stor      storage.MetricsStorer
+	templates []Template
+	listener  net.Listener
+
+	// slots limits number of served connections
+	slots chan struct{}
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewServer starts listening on address, connections above maxConns are closed right away
+func NewServer(address string, templates []Template, maxConns int, stor storage.MetricsStorer, log logger.Logger) (*Server, error) {
+	if maxConns <= 0 {
+		return nil, errors.New("connection limit must be positive")
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		log:       log,
+		stor:      stor,
+		templates: templates,
+		listener:  listener,
+		slots:     make(chan struct{}, maxConns),
+		conns:     make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Addr returns address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// RunServer accepts connections until Shutdown
+func (s *Server) RunServer() {
+	s.log.Infof("Starting graphite listener on %s", s.listener.Addr())
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Errorf("graphite accepting error %s", err)
+			continue
+		}
+
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			s.log.Errorf("Too many graphite connections, closing %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		<-s.slots
+		s.wg.Done()
+	}()
+
+	reader := bufio.NewReaderSize(conn, maxLineSize)
+	batch := make([]metrics.Metrics, 0)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		raw, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			raw, err = nil, errLineTooLong
+		}
+		if line := strings.TrimSpace(string(raw)); line != "" {
+			metric, parseErr := s.parseLine(line)
+			if parseErr != nil {
+				s.log.Errorf("graphite parsing error %s", parseErr)
+			} else if metric.Value != nil {
+				batch = append(batch, metric)
+			}
+		}
+
+		// batch is stored when client stops sending for a moment
+		if len(batch) > 0 && (err != nil || reader.Buffered() == 0) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			if setErr := s.stor.SetAll(ctx, batch); setErr != nil {
+				s.log.Errorf("Cann't store graphite metrics %s", setErr)
+			}
+			cancel()
+			batch = batch[:0]
+		}
+
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				s.log.Errorf("graphite reading error %s", err)
+			}
+			return
+		}
+	}
+}
+
+// parseLine returns gauge of line, NaN value gives metric without value
+func (s *Server) parseLine(line string) (metrics.Metrics, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return metrics.Metrics{}, fmt.Errorf("wrong line %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsInf(value, 0) {
+		return metrics.Metrics{}, fmt.Errorf("wrong value in line %q", line)
+	}
+	if len(fields) == 3 {
+		if _, err := strconv.ParseFloat(fields[2], 64); err != nil {
+			return metrics.Metrics{}, fmt.Errorf("wrong timestamp in line %q", line)
+		}
+	}
+
+	name, labels := Apply(s.templates, fields[0])
+	metric := metrics.Metrics{
+		ID:     name,
+		MType:  "gauge",
+		Labels: labels,
+	}
+	if !math.IsNaN(value) {
+		metric.Value = &value
+	}
+	return metric, nil
+}
+
+// Shutdown stops accepting connections and waits for served ones till ctx is done, then closes them
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+	}
+	return err
+}
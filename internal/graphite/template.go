@@ -0,0 +1,112 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Template maps dotted Graphite path to metric name and labels.
+// It is written as "[filter] template", e.g. "servers.* .host.measurement*".
+// Filter segments are globs, path must start with matching segments, empty filter matches everything.
+// Template part "measurement" goes to metric name, "measurement*" takes the rest of the path,
+// empty part skips segment and other parts are label names
+type Template struct {
+	filter []string
+	parts  []string
+}
+
+// ParseTemplate parses "[filter] template"
+func ParseTemplate(raw string) (Template, error) {
+	fields := strings.Fields(raw)
+	var t Template
+	switch len(fields) {
+	case 1:
+		t.parts = strings.Split(fields[0], ".")
+	case 2:
+		t.filter = strings.Split(fields[0], ".")
+		t.parts = strings.Split(fields[1], ".")
+		for _, f := range t.filter {
+			if _, err := path.Match(f, ""); err != nil {
+				return Template{}, fmt.Errorf("wrong filter %q: %w", fields[0], err)
+			}
+		}
+	default:
+		return Template{}, fmt.Errorf("wrong template %q", raw)
+	}
+
+	for _, part := range t.parts {
+		if part == "measurement" || part == "measurement*" {
+			return t, nil
+		}
+	}
+	return Template{}, errors.New("template without measurement: " + raw)
+}
+
+// ParseTemplates parses templates separated by semicolon
+func ParseTemplates(raw string) ([]Template, error) {
+	templates := make([]Template, 0)
+	for _, t := range strings.Split(raw, ";") {
+		if strings.TrimSpace(t) == "" {
+			continue
+		}
+		template, err := ParseTemplate(t)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+func (t Template) match(segments []string) bool {
+	if len(segments) < len(t.filter) {
+		return false
+	}
+	for i, f := range t.filter {
+		if ok, _ := path.Match(f, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (t Template) apply(segments []string) (string, map[string]string) {
+	name := make([]string, 0, len(segments))
+	var labels map[string]string
+	for i, part := range t.parts {
+		if i >= len(segments) {
+			break
+		}
+		switch part {
+		case "":
+		case "measurement":
+			name = append(name, segments[i])
+		case "measurement*":
+			name = append(name, segments[i:]...)
+			return strings.Join(name, "."), labels
+		default:
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[part] = segments[i]
+		}
+	}
+	return strings.Join(name, "."), labels
+}
+
+// Apply returns metric name and labels of path by first matching template,
+// path itself is the name if no template matches
+func Apply(templates []Template, metricPath string) (string, map[string]string) {
+	segments := strings.Split(metricPath, ".")
+	for _, t := range templates {
+		if !t.match(segments) {
+			continue
+		}
+		if name, labels := t.apply(segments); name != "" {
+			return name, labels
+		}
+	}
+	return metricPath, nil
+}
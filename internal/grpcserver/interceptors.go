@@ -0,0 +1,133 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metricspb"
+)
+
+func logUnary(log logger.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		log.Infof("grpc %s code %s duration %s", info.FullMethod, status.Code(err), time.Since(start))
+		return resp, err
+	}
+}
+
+func logStream(log logger.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		log.Infof("grpc %s code %s duration %s", info.FullMethod, status.Code(err), time.Since(start))
+		return err
+	}
+}
+
+// hashInterceptor is gRPC counterpart of HashSum middleware: signed requests are checked
+// and responses are signed in trailer when key is set
+type hashInterceptor struct {
+	key string
+	log logger.Logger
+}
+
+func newHashInterceptor(key string, log logger.Logger) *hashInterceptor {
+	return &hashInterceptor{key: key, log: log}
+}
+
+// requestHash returns signature sent by client, empty if request is not signed
+func requestHash(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(metricspb.HashKey)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (hi *hashInterceptor) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if reqHash := requestHash(ctx); reqHash != "" {
+		hash, err := metricspb.Sign(hi.key, req.(proto.Message))
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if !hmac.Equal([]byte(hash), []byte(reqHash)) {
+			hi.log.Error("Hash doesn't match")
+			return nil, status.Error(codes.Unauthenticated, "hash doesn't match")
+		}
+	}
+
+	resp, err := handler(ctx, req)
+	if err != nil || hi.key == "" {
+		return resp, err
+	}
+	hash, err := metricspb.Sign(hi.key, resp.(proto.Message))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	grpc.SetTrailer(ctx, metadata.Pairs(metricspb.HashKey, hash))
+	return resp, nil
+}
+
+func (hi *hashInterceptor) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	hs := &hashStream{
+		ServerStream: ss,
+		key:          hi.key,
+		expected:     requestHash(ss.Context()),
+		signer:       metricspb.NewSigner(hi.key),
+	}
+	return handler(srv, hs)
+}
+
+// hashStream signs received messages and checks signature when client closes stream,
+// so handler sees mismatch instead of io.EOF before storing anything
+type hashStream struct {
+	grpc.ServerStream
+	key      string
+	expected string
+	signer   *metricspb.Signer
+}
+
+func (hs *hashStream) RecvMsg(m any) error {
+	err := hs.ServerStream.RecvMsg(m)
+	if hs.expected == "" {
+		return err
+	}
+	if err == io.EOF {
+		if !hmac.Equal([]byte(hs.signer.Sum()), []byte(hs.expected)) {
+			return status.Error(codes.Unauthenticated, "hash doesn't match")
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if err := hs.signer.Add(m.(proto.Message)); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+func (hs *hashStream) SendMsg(m any) error {
+	if hs.key != "" {
+		hash, err := metricspb.Sign(hs.key, m.(proto.Message))
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		hs.SetTrailer(metadata.Pairs(metricspb.HashKey, hash))
+	}
+	return hs.ServerStream.SendMsg(m)
+}
@@ -0,0 +1,176 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/metricspb"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+)
+
+// MetricsServer implements gRPC Metrics service over the same storage as HTTP handlers
+type MetricsServer struct {
+	metricspb.UnimplementedMetricsServer
+
+	stor    storage.MetricsStorer
+	log     logger.Logger
+	timeout time.Duration
+}
+
+func NewMetricsServer(stor storage.MetricsStorer, log logger.Logger) *MetricsServer {
+	return &MetricsServer{
+		stor:    stor,
+		log:     log,
+		timeout: 3 * time.Second,
+	}
+}
+
+// UpdateMetrics receives batches until client closes stream and stores them at once
+func (s *MetricsServer) UpdateMetrics(stream metricspb.Metrics_UpdateMetricsServer) error {
+	batch := make([]metrics.Metrics, 0)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, m := range req.GetMetrics() {
+			metric := metricspb.ToMetric(m)
+			if err := metric.Validate(); err != nil {
+				s.log.Errorf("wrong metric %s: %s", metric.ID, err)
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+			batch = append(batch, metric)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(stream.Context(), s.timeout)
+	defer cancel()
+	if len(batch) > 0 {
+		if err := s.stor.SetAll(ctx, batch); err != nil {
+			s.log.Errorf("Cann't store metrics %s", err)
+			return storeStatus(err)
+		}
+	}
+	s.log.Infof("Stored %d metrics from grpc stream", len(batch))
+
+	return stream.SendAndClose(&metricspb.UpdateMetricsResponse{Stored: int64(len(batch))})
+}
+
+// storeStatus describes error of storing metrics like HTTP handlers do: metric that conflicts with
+// stored one is FailedPrecondition, invalid metric is InvalidArgument, timeout of storage is Unavailable
+// and any other failure of storage is Internal
+func storeStatus(err error) error {
+	var fieldErr *metrics.FieldError
+	switch {
+	case errors.Is(err, storage.ErrWrongType), errors.Is(err, metrics.ErrBucketsMismatch):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.As(err, &fieldErr):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.Unavailable, "storage timed out")
+	}
+	return status.Error(codes.Internal, "Cann't store metrics")
+}
+
+func (s *MetricsServer) GetMetric(ctx context.Context, req *metricspb.GetMetricRequest) (*metricspb.GetMetricResponse, error) {
+	if !metrics.ValidType(req.GetType()) {
+		return nil, status.Errorf(codes.InvalidArgument, "wrong type %s", req.GetType())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	metric, ok := s.stor.Get(ctx, metrics.Metrics{ID: req.GetId(), MType: req.GetType(), Labels: req.GetLabels()})
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", req.GetId())
+	}
+	return &metricspb.GetMetricResponse{Metric: metricspb.FromMetric(metric.WithQuantiles())}, nil
+}
+
+// ListMetrics returns all metrics sorted by key
+func (s *MetricsServer) ListMetrics(ctx context.Context, req *metricspb.ListMetricsRequest) (*metricspb.ListMetricsResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	all := s.stor.GetAll(ctx)
+	keys := make([]string, 0, len(all))
+	for key := range all {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	resp := &metricspb.ListMetricsResponse{Metrics: make([]*metricspb.Metric, 0, len(keys))}
+	for _, key := range keys {
+		resp.Metrics = append(resp.Metrics, metricspb.FromMetric(all[key].WithQuantiles()))
+	}
+	return resp, nil
+}
+
+// Server runs gRPC Metrics service
+type Server struct {
+	log      logger.Logger
+	listener net.Listener
+	server   *grpc.Server
+}
+
+// NewServer starts listening on address, requests are checked with HMAC key if they are signed
+func NewServer(address string, stor storage.MetricsStorer, key string, log logger.Logger) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := newHashInterceptor(key, log)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(logUnary(log), hash.unary),
+		grpc.ChainStreamInterceptor(logStream(log), hash.stream),
+	)
+	metricspb.RegisterMetricsServer(server, NewMetricsServer(stor, log))
+
+	return &Server{
+		log:      log,
+		listener: listener,
+		server:   server,
+	}, nil
+}
+
+// Addr returns address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) RunServer() {
+	s.log.Infof("Starting grpc server on %s", s.listener.Addr())
+	if err := s.server.Serve(s.listener); err != nil && err != grpc.ErrServerStopped {
+		s.log.Errorf("grpc server error %s", err)
+	}
+}
+
+// Shutdown waits for running calls till ctx is done and then stops them
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,178 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/metricspb"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+)
+
+func TestServer(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	const key = "secret"
+	srv, err := NewServer("127.0.0.1:0", stor, key, Log)
+	require.NoError(t, err)
+	go srv.RunServer()
+	defer srv.Shutdown(context.Background())
+
+	conn, err := grpc.Dial(srv.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := metricspb.NewMetricsClient(conn)
+
+	value := func(v float64) *float64 { return &v }
+	delta := func(d int64) *int64 { return &d }
+	requests := []*metricspb.UpdateMetricsRequest{
+		{Metrics: []*metricspb.Metric{
+			metricspb.FromMetric(metrics.Metrics{ID: "g", MType: "gauge", Value: value(1.5)}),
+			metricspb.FromMetric(metrics.Metrics{ID: "c", MType: "counter", Delta: delta(2)}),
+		}},
+		{Metrics: []*metricspb.Metric{
+			metricspb.FromMetric(metrics.Metrics{ID: "c", MType: "counter", Delta: delta(3), Labels: map[string]string{"host": "a"}}),
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		requests []*metricspb.UpdateMetricsRequest
+		wantCode codes.Code
+	}{
+		{
+			name:     "signed stream",
+			key:      key,
+			requests: requests,
+			wantCode: codes.OK,
+		},
+		{
+			name:     "wrong signature",
+			key:      "other",
+			requests: requests,
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "unsigned stream",
+			requests: requests[:1],
+			wantCode: codes.OK,
+		},
+		{
+			name: "wrong type",
+			requests: []*metricspb.UpdateMetricsRequest{{Metrics: []*metricspb.Metric{
+				{Id: "x", Type: "smth"},
+			}}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "type conflict",
+			requests: []*metricspb.UpdateMetricsRequest{{Metrics: []*metricspb.Metric{
+				metricspb.FromMetric(metrics.Metrics{ID: "g", MType: "counter", Delta: delta(1)}),
+			}}},
+			wantCode: codes.FailedPrecondition,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if tt.key != "" {
+				hash, err := metricspb.Sign(tt.key, requestsMessages(tt.requests)...)
+				require.NoError(t, err)
+				ctx = metadata.AppendToOutgoingContext(ctx, metricspb.HashKey, hash)
+			}
+
+			stream, err := client.UpdateMetrics(ctx)
+			require.NoError(t, err)
+			for _, req := range tt.requests {
+				require.NoError(t, stream.Send(req))
+			}
+			_, err = stream.CloseAndRecv()
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+
+	ctx := context.Background()
+	// two accepted streams with c
+	c, ok := stor.Get(ctx, metrics.Metrics{ID: "c", MType: "counter"})
+	require.True(t, ok)
+	assert.Equal(t, int64(4), *c.Delta)
+
+	var trailer metadata.MD
+	resp, err := client.GetMetric(ctx, &metricspb.GetMetricRequest{Id: "c", Type: "counter", Labels: map[string]string{"host": "a"}}, grpc.Trailer(&trailer))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), resp.GetMetric().GetDelta())
+	hash, err := metricspb.Sign(key, resp)
+	require.NoError(t, err)
+	assert.Equal(t, []string{hash}, trailer.Get(metricspb.HashKey))
+
+	_, err = client.GetMetric(ctx, &metricspb.GetMetricRequest{Id: "unknown", Type: "gauge"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err := client.ListMetrics(ctx, &metricspb.ListMetricsRequest{})
+	require.NoError(t, err)
+	ids := make([]string, 0)
+	for _, m := range list.GetMetrics() {
+		ids = append(ids, metricspb.ToMetric(m).Key())
+	}
+	assert.Equal(t, []string{"c", `c{host="a"}`, "g"}, ids)
+}
+
+func requestsMessages(requests []*metricspb.UpdateMetricsRequest) []proto.Message {
+	msgs := make([]proto.Message, 0, len(requests))
+	for _, req := range requests {
+		msgs = append(msgs, req)
+	}
+	return msgs
+}
+
+func TestStoreStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+	}{
+		{
+			name:     "type conflict",
+			err:      &storage.BatchError{Index: 1, Err: storage.ErrWrongType},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "invalid metric",
+			err:      &metrics.FieldError{Field: "delta", Message: "counter without delta"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "timeout",
+			err:      fmt.Errorf("query: %w", context.DeadlineExceeded),
+			wantCode: codes.Unavailable,
+		},
+		{
+			name:     "storage failure",
+			err:      errors.New("connection refused"),
+			wantCode: codes.Internal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantCode, status.Code(storeStatus(tt.err)))
+		})
+	}
+}
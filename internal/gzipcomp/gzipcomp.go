@@ -48,10 +48,11 @@ func (gw *GzipCompressWiter) Close() error {
 	return gw.zw.Close()
 }
 
-// GzipResponseWriter stores response before possible compression
+// GzipResponseWriter stores response and status before possible compression
 type GzipResponseWriter struct {
 	w      http.ResponseWriter
 	buffer *bytes.Buffer
+	status int
 }
 
 func NewGzipResponseWriter(w http.ResponseWriter) *GzipResponseWriter {
@@ -72,8 +73,16 @@ func (rw *GzipResponseWriter) Write(data []byte) (int, error) {
 	return rw.buffer.Write(data)
 }
 
+// WriteHeader only remembers status, it is written with the body
 func (rw *GzipResponseWriter) WriteHeader(statusCode int) {
-	rw.w.WriteHeader(statusCode)
+	if rw.status == 0 {
+		rw.status = statusCode
+	}
+}
+
+// Status returns status set by handler or 0 if WriteHeader was not called
+func (rw *GzipResponseWriter) Status() int {
+	return rw.status
 }
 
 // GzipCompressReader is Readcloser with gzip decompression
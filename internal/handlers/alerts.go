@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/alerting"
+)
+
+// AlertLister gives currently firing alerts
+type AlertLister interface {
+	Firing() []alerting.Alert
+}
+
+// AlertsHandler returns firing alerts as json
+func (h *Handler) AlertsHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered AlertsHandler")
+
+	if r.Method != http.MethodGet {
+		h.failMethod(w, r, "Only GET requests are allowed for alerts!")
+
+		return
+	}
+
+	alerts := make([]alerting.Alert, 0)
+	if h.alerts != nil {
+		alerts = h.alerts.Firing()
+	}
+
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		h.logger.Error("json marhsaling error")
+		h.failInternal(w, r, "json marhsaling error")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+	h.logger.Info("AlertsHandler exited")
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+)
+
+// Modes of JSONUpdAllHandler selected by ?mode=
+const (
+	// ModePartial stores valid metrics of batch and reports the rest
+	ModePartial = "partial"
+	// ModeAtomic stores all metrics of batch or none of them
+	ModeAtomic = "atomic"
+)
+
+// Statuses of updateResult
+const (
+	StatusStored  = "stored"
+	StatusInvalid = "invalid"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// updateResult is outcome of one metric of batch, Metric is its stored value
+type updateResult struct {
+	Index  int              `json:"index"`
+	ID     string           `json:"id"`
+	MType  string           `json:"type"`
+	Status string           `json:"status"`
+	Metric *metrics.Metrics `json:"metric,omitempty"`
+	Error  *APIError        `json:"error,omitempty"`
+}
+
+// storeBatch stores batch in partial or atomic mode and answers with result of every metric.
+// Partial mode answers 200 if all metrics are stored and 207 otherwise,
+// atomic mode answers 200 or status of failed metric with the rest skipped
+func (h *Handler) storeBatch(w http.ResponseWriter, r *http.Request, mode string, batch []metrics.Metrics) {
+	setter, ok := h.stor.(storage.BatchSetter)
+	if !ok {
+		h.fail(w, r, http.StatusNotImplemented, APIError{Code: CodeNotImplemented, Message: "storage does not support batch modes"})
+
+		return
+	}
+
+	results := make([]updateResult, len(batch))
+	valid := make([]metrics.Metrics, 0, len(batch))
+	index := make([]int, 0, len(batch))
+	for i, m := range batch {
+		results[i] = updateResult{Index: i, ID: m.ID, MType: m.MType, Status: StatusSkipped}
+		if err := m.Validate(); err != nil {
+			h.logger.Errorf("wrong metric %s: %s", m.ID, err)
+			results[i].Status = StatusInvalid
+			apiErr := fieldError(CodeInvalidMetric, err)
+			results[i].Error = &apiErr
+
+			continue
+		}
+		valid = append(valid, m)
+		index = append(index, i)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	status := http.StatusOK
+	switch {
+	case mode == ModeAtomic && len(valid) < len(batch):
+		status = http.StatusBadRequest
+
+	case mode == ModeAtomic:
+		stored, err := setter.SetAtomic(ctx, valid)
+		var batchErr *storage.BatchError
+		if errors.As(err, &batchErr) {
+			h.logger.Errorf("Batch is rejected %s", err)
+			var apiErr APIError
+			status, apiErr = storeFailure(batchErr.Err)
+			results[index[batchErr.Index]].Status = StatusFailed
+			results[index[batchErr.Index]].Error = &apiErr
+
+			break
+		}
+		if err != nil {
+			h.logger.Errorf("Cann't store metrics %s", err)
+			h.failStorage(w, r, err)
+
+			return
+		}
+		for i, m := range stored {
+			results[index[i]].stored(m)
+		}
+
+	default:
+		stored, errs := setter.SetEach(ctx, valid)
+		for i, err := range errs {
+			if err != nil {
+				h.logger.Errorf("Cann't store metric %s %s", valid[i].ID, err)
+				_, apiErr := storeFailure(err)
+				results[index[i]].Status = StatusFailed
+				results[index[i]].Error = &apiErr
+
+				continue
+			}
+			results[index[i]].stored(stored[i])
+		}
+		if len(valid) < len(batch) || failed(errs) {
+			status = http.StatusMultiStatus
+		}
+	}
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		h.logger.Error("json marhsaling error")
+		h.failInternal(w, r, "json marhsaling error")
+
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (res *updateResult) stored(m metrics.Metrics) {
+	m = m.WithQuantiles()
+	res.Status = StatusStored
+	res.Metric = &m
+}
+
+func failed(errs []error) bool {
+	for _, err := range errs {
+		if err != nil {
+			return true
+		}
+	}
+	return false
+}
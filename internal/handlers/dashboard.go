@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// Size of sparkline in svg units
+const (
+	sparkWidth  = 600
+	sparkHeight = 120
+)
+
+//go:embed ui
+var uiFS embed.FS
+
+var (
+	staticFS   = mustSub(uiFS, "ui/static")
+	indexPage  = mustPage("index.html")
+	metricPage = mustPage("metric.html")
+)
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// mustPage parses page with layout, every page defines its own content
+func mustPage(name string) *template.Template {
+	return template.Must(template.ParseFS(uiFS, "ui/templates/layout.html", "ui/templates/"+name))
+}
+
+// metricRow is metric prepared for templates
+type metricRow struct {
+	ID     string
+	MType  string
+	Labels string
+	Value  string
+	Link   string
+	// Request is body of POST /value/ used to refresh the value
+	Request string
+	Search  string
+}
+
+func newMetricRow(m metrics.Metrics) metricRow {
+	request, _ := json.Marshal(struct {
+		ID     string            `json:"id"`
+		MType  string            `json:"type"`
+		Labels map[string]string `json:"labels,omitempty"`
+	}{ID: m.ID, MType: m.MType, Labels: m.Labels})
+	labels := labelsText(m.Labels)
+
+	link := "/ui/" + url.PathEscape(m.MType) + "/" + url.PathEscape(m.ID)
+	if len(m.Labels) > 0 {
+		query := url.Values{}
+		for k, v := range m.Labels {
+			query.Set(k, v)
+		}
+		link += "?" + query.Encode()
+	}
+
+	return metricRow{
+		ID:      m.ID,
+		MType:   m.MType,
+		Labels:  labels,
+		Value:   formatValue(m.WithQuantiles()),
+		Link:    link,
+		Request: string(request),
+		Search:  strings.ToLower(m.ID + " " + labels),
+	}
+}
+
+// ShowAllHandler returns dashboard with table of metrics, ?q= filters them by name and labels
+func (h *Handler) ShowAllHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered ShowAllHandler")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only Get requests are allowed for value!", http.StatusMethodNotAllowed)
+
+		return
+	}
+	h.logger.Info("method checked")
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	rows := make([]metricRow, 0)
+	for _, m := range h.stor.GetAll(ctx) {
+		row := newMetricRow(m)
+		if strings.Contains(row.Search, strings.ToLower(query)) {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].ID != rows[j].ID {
+			return rows[i].ID < rows[j].ID
+		}
+		if rows[i].Labels != rows[j].Labels {
+			return rows[i].Labels < rows[j].Labels
+		}
+		return rows[i].MType < rows[j].MType
+	})
+	h.logger.Info("Metrics collected")
+
+	h.render(w, indexPage, struct {
+		Query string
+		Rows  []metricRow
+	}{Query: query, Rows: rows})
+}
+
+// MetricPageHandler returns page of one metric with sparkline of last hour,
+// query parameters are labels of metric
+func (h *Handler) MetricPageHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered MetricPageHandler")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only Get requests are allowed for metric page!", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	metric := metrics.Metrics{ID: chi.URLParam(r, "name"), MType: chi.URLParam(r, "type"), Labels: queryLabels(r)}
+	if !metrics.ValidType(metric.MType) {
+		http.Error(w, "wrong type", http.StatusBadRequest)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	stored, ok := h.stor.Get(ctx, metric)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s not found", metric.ID), http.StatusNotFound)
+
+		return
+	}
+
+	values := make([]float64, 0)
+	historyEnabled := false
+	if historian, ok := h.stor.(storage.MetricsHistorian); ok {
+		to := time.Now()
+		if samples, err := historian.History(ctx, metric, to.Add(-time.Hour), to); err == nil {
+			historyEnabled = true
+			for _, s := range samples {
+				values = append(values, s.Value)
+			}
+		}
+	}
+	samples, err := json.Marshal(values)
+	if err != nil {
+		h.logger.Error("json marhsaling error")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	row := newMetricRow(stored)
+	h.render(w, metricPage, struct {
+		metricRow
+		Buckets        []metrics.Bucket
+		HistoryEnabled bool
+		Samples        string
+		SampleCount    int
+		Points         string
+		Width, Height  int
+	}{
+		metricRow:      row,
+		Buckets:        stored.Buckets,
+		HistoryEnabled: historyEnabled,
+		Samples:        string(samples),
+		SampleCount:    len(values),
+		Points:         sparkline(values, sparkWidth, sparkHeight),
+		Width:          sparkWidth,
+		Height:         sparkHeight,
+	})
+}
+
+// StaticHandler serves embedded styles, scripts and images
+func (h *Handler) StaticHandler(w http.ResponseWriter, r *http.Request) {
+	http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))).ServeHTTP(w, r)
+}
+
+// FaviconHandler returns Gopher!!!!
+func (h *Handler) FaviconHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered FaviconHandler")
+
+	icon, err := fs.ReadFile(staticFS, "gopher.png")
+	if err != nil {
+		http.Error(w, "Иконка не найдена", http.StatusNotFound)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+
+	w.Write(icon)
+}
+
+// render executes page into buffer, so template error doesn't leave half of page
+func (h *Handler) render(w http.ResponseWriter, page *template.Template, data any) {
+	var buf bytes.Buffer
+	if err := page.ExecuteTemplate(&buf, "layout", data); err != nil {
+		h.logger.Errorf("Error rendering page %s", err)
+		http.Error(w, "cann't render page", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(buf.Bytes())
+}
+
+// formatValue returns short text of metric value, dashboard.js formats values the same way
+func formatValue(m metrics.Metrics) string {
+	number := func(v *float64) string {
+		if v == nil {
+			return "0"
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+	count := func(v *int64) string {
+		if v == nil {
+			return "0"
+		}
+		return strconv.FormatInt(*v, 10)
+	}
+
+	switch m.MType {
+	case "gauge":
+		return number(m.Value)
+	case "counter":
+		return count(m.Delta)
+	case "histogram":
+		return "count " + count(m.Count) + ", sum " + number(m.Sum)
+	case "summary":
+		res := "count " + count(m.Count) + ", sum " + number(m.Sum)
+		for _, q := range metrics.DefaultQuantiles {
+			key := strconv.FormatFloat(q, 'g', -1, 64)
+			if v, ok := m.Quantiles[key]; ok {
+				res += ", q" + key + " " + number(&v)
+			}
+		}
+		return res
+	}
+	return ""
+}
+
+// labelsText returns labels sorted by name like name="value", ...
+func labelsText(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%q", name, labels[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sparkline returns points of svg polyline scaled to width and height
+func sparkline(values []float64, width, height float64) string {
+	if len(values) < 2 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	span := hi - lo
+	if span == 0 {
+		span = 1
+	}
+
+	points := make([]string, len(values))
+	for i, v := range values {
+		x := float64(i) * width / float64(len(values)-1)
+		y := height - (v-lo)/span*height
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return strings.Join(points, " ")
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+)
+
+// Codes of APIError
+const (
+	CodeBadRequest       = "bad_request"
+	CodeInvalidJSON      = "invalid_json"
+	CodeInvalidMetric    = "invalid_metric"
+	CodeInvalidParam     = "invalid_param"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeNotImplemented   = "not_implemented"
+	CodeConflict         = "type_conflict"
+	CodeStorage          = "storage_error"
+	CodeInternal         = "internal_error"
+)
+
+// APIError is error of /api/v1 request, Field is JSON name of wrong field or query parameter
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+// errorEnvelope is body of /api/v1 error response
+type errorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+type ctxKey int
+
+const apiVersionKey ctxKey = iota
+
+// apiV1 marks requests of versioned routes, their errors are answered with errorEnvelope
+func apiV1(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey, 1)))
+	})
+}
+
+func isAPIRequest(r *http.Request) bool {
+	return r.Context().Value(apiVersionKey) != nil
+}
+
+// fail answers request with error: JSON envelope under /api/v1 and plain message on unversioned routes
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, status int, apiErr APIError) {
+	if !isAPIRequest(r) {
+		http.Error(w, apiErr.Message, status)
+
+		return
+	}
+
+	body, err := json.Marshal(errorEnvelope{Error: apiErr})
+	if err != nil {
+		h.logger.Error("json marhsaling error")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// failInvalid answers with 400, field is taken from metrics.FieldError
+func (h *Handler) failInvalid(w http.ResponseWriter, r *http.Request, code string, err error) {
+	h.fail(w, r, http.StatusBadRequest, fieldError(code, err))
+}
+
+// fieldError describes err with code, field is taken from metrics.FieldError
+func fieldError(code string, err error) APIError {
+	apiErr := APIError{Code: code, Message: err.Error()}
+	var fieldErr *metrics.FieldError
+	if errors.As(err, &fieldErr) {
+		apiErr.Field = fieldErr.Field
+	}
+	return apiErr
+}
+
+// storeFailure describes error of storing metrics: metric that conflicts with stored one or is invalid is 400,
+// timeout of storage is 503 and any other failure of storage is 500
+func storeFailure(err error) (int, APIError) {
+	var fieldErr *metrics.FieldError
+	switch {
+	case errors.Is(err, storage.ErrWrongType), errors.Is(err, metrics.ErrBucketsMismatch):
+		return http.StatusBadRequest, APIError{Code: CodeConflict, Message: err.Error()}
+	case errors.As(err, &fieldErr):
+		return http.StatusBadRequest, fieldError(CodeInvalidMetric, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, APIError{Code: CodeStorage, Message: "storage timed out"}
+	}
+	return http.StatusInternalServerError, APIError{Code: CodeStorage, Message: "Cann't store metrics"}
+}
+
+// failStorage answers to error returned by storage on storing metrics
+func (h *Handler) failStorage(w http.ResponseWriter, r *http.Request, err error) {
+	status, apiErr := storeFailure(err)
+	h.fail(w, r, status, apiErr)
+}
+
+// failMethod answers request sent with wrong method
+func (h *Handler) failMethod(w http.ResponseWriter, r *http.Request, message string) {
+	h.fail(w, r, http.StatusMethodNotAllowed, APIError{Code: CodeMethodNotAllowed, Message: message})
+}
+
+// failInternal answers with 500 when response can't be built
+func (h *Handler) failInternal(w http.ResponseWriter, r *http.Request, message string) {
+	h.fail(w, r, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: message})
+}
+
+// failNotFound answers request for unknown metric
+func (h *Handler) failNotFound(w http.ResponseWriter, r *http.Request, name string) {
+	h.fail(w, r, http.StatusNotFound, APIError{Code: CodeNotFound, Message: name + " not found"})
+}
@@ -3,38 +3,64 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
-	"os"
-	"sort"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/Mr-Punder/go-alerting-service/internal/ingest"
 	"github.com/Mr-Punder/go-alerting-service/internal/logger"
 	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
 	"github.com/Mr-Punder/go-alerting-service/internal/storage"
 	"github.com/go-chi/chi/v5"
 )
 
+const (
+	// IdempotencyKeyHeader carries key of batch update, batch sent again with the same key is stored once
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks answer to batch that was already stored with the same key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKey = 64
+)
+
 // Handler type contains MemStorer and HttpLogger
 type Handler struct {
 	stor    storage.MetricsStorer
+	alerts  AlertLister
 	logger  logger.Logger
 	timeout time.Duration
+
+	remoteWriteMu sync.Mutex
+	remoteTotals  *ingest.Totals
+	otlpMu        sync.Mutex
+	otlpTotals    *ingest.Totals
 }
 
-func NewHandler(stor storage.MetricsStorer, logger logger.Logger) *Handler {
-	return &Handler{stor, logger, 3 * time.Second}
+func NewHandler(stor storage.MetricsStorer, alerts AlertLister, logger logger.Logger) *Handler {
+	return &Handler{
+		stor:    stor,
+		alerts:  alerts,
+		logger:  logger,
+		timeout: 3 * time.Second,
+
+		remoteTotals: ingest.NewTotals(),
+		otlpTotals:   ingest.NewTotals(),
+	}
 }
 
-func NewMetricRouter(storage storage.MetricsStorer, logger logger.Logger) chi.Router {
+func NewMetricRouter(storage storage.MetricsStorer, alerts AlertLister, logger logger.Logger) chi.Router {
 	r := chi.NewRouter()
 
-	handler := NewHandler(storage, logger)
+	handler := NewHandler(storage, alerts, logger)
 
 	return r.Route("/", func(r chi.Router) {
 		r.Get("/", handler.ShowAllHandler)
 		r.Post("/updates/", handler.JSONUpdAllHandler)
+		r.Post("/write", handler.InfluxWriteHandler)
 		r.Route("/update", func(r chi.Router) {
 			r.Post("/", handler.JSONUpdHandler)
 			r.Post("/{type}/{name}/{value}", handler.UpdHandler)
@@ -44,17 +70,56 @@ func NewMetricRouter(storage storage.MetricsStorer, logger logger.Logger) chi.Ro
 			r.Get("/{type}/{name}", handler.ValueHandler)
 		})
 		r.Get("/ping", handler.PingHandler)
+		r.Get("/alerts", handler.AlertsHandler)
+		r.Get("/history/{type}/{name}", handler.HistoryHandler)
+		r.Get("/query/{type}/{name}", handler.RangeHandler)
+		r.Get("/metrics", handler.PrometheusHandler)
+		r.Get("/api/metrics", handler.MetricsQueryHandler)
+		r.Get("/stream", handler.StreamHandler)
+		r.Route("/api/v1", handler.apiV1Routes)
+		r.Post("/v1/metrics", handler.OTLPHandler)
+		r.Get("/ui/{type}/{name}", handler.MetricPageHandler)
+		r.Get("/static/*", handler.StaticHandler)
 		r.Get("/favicon.ico", handler.FaviconHandler)
 		r.Get("/{}", handler.DefoultHandler)
 		r.Post("/{}", handler.DefoultHandler)
 	})
 }
 
+// apiV1Routes mounts versioned API, all its errors are answered with JSON envelope
+func (h *Handler) apiV1Routes(r chi.Router) {
+	r.Use(apiV1)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		h.fail(w, r, http.StatusNotFound, APIError{Code: CodeNotFound, Message: "no such endpoint"})
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		h.failMethod(w, r, r.Method+" is not allowed for "+r.URL.Path)
+	})
+
+	r.Post("/updates", h.JSONUpdAllHandler)
+	r.Post("/update", h.JSONUpdHandler)
+	r.Post("/update/{type}/{name}/{value}", h.UpdHandler)
+	r.Post("/value", h.JSONValueHandler)
+	r.Get("/value/{type}/{name}", h.ValueHandler)
+	r.Get("/metrics", h.MetricsQueryHandler)
+	r.Get("/history/{type}/{name}", h.HistoryHandler)
+	r.Get("/query/{type}/{name}", h.RangeHandler)
+	r.Get("/stream", h.StreamHandler)
+	r.Get("/alerts", h.AlertsHandler)
+	r.Get("/ping", h.PingHandler)
+	r.Post("/write", h.RemoteWriteHandler)
+	r.Post("/influx/write", h.InfluxWriteHandler)
+	// OTLP exporters append /v1/metrics to endpoint, so endpoint is /api/v1/otlp
+	r.Post("/otlp/v1/metrics", h.OTLPHandler)
+}
+
+// JSONUpdAllHandler stores batch of metrics. Without ?mode= the whole batch is rejected by
+// any wrong metric, ?mode=partial and ?mode=atomic answer with result of every metric
 func (h *Handler) JSONUpdAllHandler(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("Entered JSONUpdAllHandler")
 	if r.Method != http.MethodPost {
 		h.logger.Error("wrong request method")
-		http.Error(w, "Only POST requests are allowed for update!", http.StatusMethodNotAllowed)
+		h.failMethod(w, r, "Only POST requests are allowed for update!")
 
 		return
 	}
@@ -63,43 +128,178 @@ func (h *Handler) JSONUpdAllHandler(w http.ResponseWriter, r *http.Request) {
 	metrics := make([]metrics.Metrics, 0)
 	if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
 		h.logger.Error(fmt.Sprintf("json decoding error %e", err))
-		w.WriteHeader(http.StatusBadRequest)
-		http.Error(w, "wrong requests", http.StatusBadRequest)
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: "wrong requests"})
 
 		return
 	}
 
-	for _, m := range metrics {
-		if m.MType != "gauge" && m.MType != "counter" {
-			h.logger.Error(fmt.Sprintf("wrong type %s", m.MType))
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if len(key) > maxIdempotencyKey {
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "idempotency key is too long", Field: IdempotencyKeyHeader})
 
-			http.Error(w, "wrong type", http.StatusBadRequest)
+		return
+	}
+
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "":
+	case ModePartial, ModeAtomic:
+		if key != "" {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "idempotency key is not supported in mode " + mode, Field: IdempotencyKeyHeader})
 
 			return
 		}
+		h.storeBatch(w, r, mode, metrics)
+		h.logger.Info("JSONUpdAllHandler exited")
+
+		return
+	default:
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong mode " + mode, Field: "mode"})
+
+		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
 	defer cancel()
-	if err := h.stor.SetAll(ctx, metrics); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		h.logger.Errorf("Cann't store metrics", err)
+	replayed, err := h.storeAllOnce(ctx, key, metrics)
+	if err != nil {
+		h.failStore(w, r, err)
 
 		return
 	}
 
-	h.logger.Info("Metrics stored")
+	if replayed {
+		h.logger.Infof("Batch with key %s is already stored", key)
+		w.Header().Set(IdempotentReplayedHeader, "true")
+	} else {
+		h.logger.Info("Metrics stored")
+	}
 	w.WriteHeader(http.StatusOK)
 	h.logger.Info("JSONUpdHandler exited")
 
 }
 
+// storeAll validates every metric of batch and stores batch,
+// validation error is returned as is and storage error is wrapped in storeError
+func (h *Handler) storeAll(ctx context.Context, batch []metrics.Metrics) error {
+	if err := h.validateAll(batch); err != nil {
+		return err
+	}
+
+	if err := h.stor.SetAll(ctx, batch); err != nil {
+		h.logger.Errorf("Cann't store metrics %s", err)
+
+		return &storeError{err: err}
+	}
+	return nil
+}
+
+// storeAllOnce is storeAll that skips batch already stored with key, replayed tells that batch was skipped
+func (h *Handler) storeAllOnce(ctx context.Context, key string, batch []metrics.Metrics) (bool, error) {
+	setter, ok := h.stor.(storage.IdempotentSetter)
+	if key == "" || !ok {
+		return false, h.storeAll(ctx, batch)
+	}
+	if err := h.validateAll(batch); err != nil {
+		return false, err
+	}
+
+	applied, err := setter.SetAllOnce(ctx, key, batch)
+	if err != nil {
+		h.logger.Errorf("Cann't store metrics %s", err)
+
+		return false, &storeError{err: err}
+	}
+	return !applied, nil
+}
+
+func (h *Handler) validateAll(batch []metrics.Metrics) error {
+	for _, m := range batch {
+		if err := m.Validate(); err != nil {
+			h.logger.Error(fmt.Sprintf("wrong metric %s: %s", m.ID, err))
+
+			return err
+		}
+	}
+	return nil
+}
+
+// storeError is error of storage, not of stored metrics
+type storeError struct {
+	err error
+}
+
+func (e *storeError) Error() string {
+	return e.err.Error()
+}
+
+// failStore answers to error of storeAll, invalid metric is 400 and storage error is answered by failStorage
+func (h *Handler) failStore(w http.ResponseWriter, r *http.Request, err error) {
+	var se *storeError
+	if errors.As(err, &se) {
+		h.failStorage(w, r, se.err)
+
+		return
+	}
+	h.failInvalid(w, r, CodeInvalidMetric, err)
+}
+
+// influxResponse reports lines of InfluxWriteHandler request that were not stored
+type influxResponse struct {
+	Stored int                `json:"stored"`
+	Errors []ingest.LineError `json:"errors"`
+}
+
+// InfluxWriteHandler stores metrics sent in Influx line protocol.
+// Valid lines are stored even if other lines fail, failed lines are reported with 400
+func (h *Handler) InfluxWriteHandler(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("Entered InfluxWriteHandler")
+	if r.Method != http.MethodPost {
+		h.logger.Error("wrong request method")
+		h.failMethod(w, r, "Only POST requests are allowed for write!")
+
+		return
+	}
+	h.logger.Info("Method checked")
+
+	batch, lineErrors := ingest.ParseInflux(r.Body)
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	if len(batch) > 0 {
+		if err := h.storeAll(ctx, batch); err != nil {
+			h.failStore(w, r, err)
+
+			return
+		}
+	}
+
+	if len(lineErrors) == 0 {
+		h.logger.Infof("Stored %d metrics from line protocol", len(batch))
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	h.logger.Errorf("%d lines of line protocol are not parsed", len(lineErrors))
+	body, err := json.Marshal(influxResponse{Stored: len(batch), Errors: lineErrors})
+	if err != nil {
+		h.logger.Error("json marhsaling error")
+		h.failInternal(w, r, "json marhsaling error")
+
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(body)
+	h.logger.Info("InfluxWriteHandler exited")
+}
+
 func (h *Handler) PingHandler(w http.ResponseWriter, r *http.Request) {
 
 	h.logger.Info("Entered PingHandler")
 	if r.Method != http.MethodGet {
 		h.logger.Error("wrong request method")
-		http.Error(w, "Only GET requests are allowed for update!", http.StatusMethodNotAllowed)
+		h.failMethod(w, r, "Only GET requests are allowed for ping!")
 
 		return
 	}
@@ -108,8 +308,7 @@ func (h *Handler) PingHandler(w http.ResponseWriter, r *http.Request) {
 	err := h.stor.Ping()
 	if err != nil {
 		h.logger.Errorf("Database does not ping %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		http.Error(w, "Database does not ping", http.StatusInternalServerError)
+		h.fail(w, r, http.StatusInternalServerError, APIError{Code: CodeStorage, Message: "Database does not ping"})
 
 		return
 	}
@@ -125,7 +324,7 @@ func (h *Handler) JSONUpdHandler(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("Entered JSONUpdHandler")
 	if r.Method != http.MethodPost {
 		h.logger.Error("wrong request method")
-		http.Error(w, "Only POST requests are allowed for update!", http.StatusMethodNotAllowed)
+		h.failMethod(w, r, "Only POST requests are allowed for update!")
 
 		return
 	}
@@ -135,16 +334,14 @@ func (h *Handler) JSONUpdHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
 		h.logger.Error(fmt.Sprintf("json decoding error %e", err))
-		w.WriteHeader(http.StatusBadRequest)
-		http.Error(w, "wrong requests", http.StatusBadRequest)
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: "wrong requests"})
 
 		return
 	}
 	h.logger.Info(fmt.Sprintf("Decoded metric to stor %v", metric))
-	if metric.MType != "gauge" && metric.MType != "counter" {
-		h.logger.Error(fmt.Sprintf("wrong type %s", metric.MType))
-
-		http.Error(w, "wrong type", http.StatusBadRequest)
+	if err := metric.Validate(); err != nil {
+		h.logger.Error(fmt.Sprintf("wrong metric %s: %s", metric.ID, err))
+		h.failInvalid(w, r, CodeInvalidMetric, err)
 
 		return
 	}
@@ -161,8 +358,8 @@ func (h *Handler) JSONUpdHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel = context.WithTimeout(r.Context(), h.timeout)
 	defer cancel()
 	if err := h.stor.Set(ctx, metric); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		h.logger.Errorf("Cann't store metric", err)
+		h.logger.Errorf("Cann't store metric %s", err)
+		h.failStorage(w, r, err)
 
 		return
 	}
@@ -173,16 +370,16 @@ func (h *Handler) JSONUpdHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	respMetric, _ := h.stor.Get(ctx, metric)
-	w.Header().Set("Content-Type", "application/json")
 
-	body, err := json.Marshal(respMetric)
+	body, err := json.Marshal(respMetric.WithQuantiles())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		h.logger.Error("json marhsaling error")
+		h.failInternal(w, r, "json marhsaling error")
 
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(body)
 	h.logger.Info("JSONUpdHandler exited")
 
@@ -194,8 +391,8 @@ func (h *Handler) JSONValueHandler(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("Entered JSONValueHandler")
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST requests are allowed for update!", http.StatusMethodNotAllowed)
 		h.logger.Error("wrong request method")
+		h.failMethod(w, r, "Only POST requests are allowed for value!")
 
 		return
 	}
@@ -206,16 +403,16 @@ func (h *Handler) JSONValueHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
 		h.logger.Error("json decoding error")
-		http.Error(w, "wrong requests", http.StatusBadRequest)
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: "wrong requests"})
 
 		return
 	}
 
 	h.logger.Info(fmt.Sprintf("Decoded metric %v", metric))
 
-	if metric.MType != "gauge" && metric.MType != "counter" {
+	if !metrics.ValidType(metric.MType) {
 		h.logger.Error(fmt.Sprintf("wrong type %s", metric.MType))
-		http.Error(w, "wrong type", http.StatusBadRequest)
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidMetric, Message: "wrong type", Field: "type"})
 
 		return
 	}
@@ -233,20 +430,20 @@ func (h *Handler) JSONValueHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		h.logger.Info(str)
 		h.logger.Error("Cann't find metric")
-		http.Error(w, fmt.Sprintf("%s not found", metric.ID), http.StatusNotFound)
+		h.failNotFound(w, r, metric.ID)
 
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
 
-	body, err := json.Marshal(respMetric)
+	body, err := json.Marshal(respMetric.WithQuantiles())
 	if err != nil {
 		h.logger.Error("json marhsaling error")
-		w.WriteHeader(http.StatusInternalServerError)
+		h.failInternal(w, r, "json marhsaling error")
 
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(body)
 
 }
@@ -257,7 +454,7 @@ func (h *Handler) UpdHandler(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("Entered UpdHandler")
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST requests are allowed for update!", http.StatusMethodNotAllowed)
+		h.failMethod(w, r, "Only POST requests are allowed for update!")
 
 		return
 	}
@@ -270,7 +467,7 @@ func (h *Handler) UpdHandler(w http.ResponseWriter, r *http.Request) {
 	case "gauge":
 		fval, err := strconv.ParseFloat(val, 64)
 		if err != nil {
-			http.Error(w, "wrong format value", http.StatusBadRequest)
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidMetric, Message: "wrong format value", Field: "value"})
 
 			return
 		}
@@ -285,7 +482,7 @@ func (h *Handler) UpdHandler(w http.ResponseWriter, r *http.Request) {
 		defer cancel()
 
 		if err := h.stor.Set(ctx, metric); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			h.failStorage(w, r, err)
 
 			return
 		}
@@ -293,7 +490,7 @@ func (h *Handler) UpdHandler(w http.ResponseWriter, r *http.Request) {
 	case "counter":
 		ival, err := strconv.ParseInt(val, 10, 64)
 		if err != nil {
-			http.Error(w, "wrong format value", http.StatusBadRequest)
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidMetric, Message: "wrong format value", Field: "value"})
 
 			return
 		}
@@ -307,12 +504,57 @@ func (h *Handler) UpdHandler(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
 		defer cancel()
 		if err := h.stor.Set(ctx, metric); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			h.failStorage(w, r, err)
+
+			return
+		}
+
+	case "histogram":
+		fval, err := strconv.ParseFloat(val, 64)
+		if err != nil || math.IsNaN(fval) || math.IsInf(fval, 0) {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidMetric, Message: "wrong format value", Field: "value"})
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+		defer cancel()
+
+		// observation goes into buckets of existing histogram
+		bounds := metrics.DefaultBuckets
+		if stored, ok := h.stor.Get(ctx, metrics.Metrics{ID: name, MType: tp}); ok && stored.MType == tp {
+			bounds = stored.Bounds()
+		}
+
+		if err := h.stor.Set(ctx, metrics.NewHistogram(name, bounds, fval)); err != nil {
+			h.failStorage(w, r, err)
+
+			return
+		}
+
+	case "summary":
+		fval, err := strconv.ParseFloat(val, 64)
+		if err != nil || math.IsNaN(fval) || math.IsInf(fval, 0) {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidMetric, Message: "wrong format value", Field: "value"})
+
+			return
+		}
+
+		metric := metrics.Metrics{
+			ID:    name,
+			MType: tp,
+			Value: &fval,
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+		defer cancel()
+		if err := h.stor.Set(ctx, metric); err != nil {
+			h.failStorage(w, r, err)
 
 			return
 		}
 	default:
-		http.Error(w, "wrong type", http.StatusBadRequest)
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidMetric, Message: "wrong type", Field: "type"})
 
 		return
 	}
@@ -326,7 +568,7 @@ func (h *Handler) DefoultHandler(w http.ResponseWriter, r *http.Request) {
 
 	h.logger.Info("Entered DefoultHandler")
 
-	http.Error(w, "wrong requests", http.StatusBadRequest)
+	h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "wrong requests"})
 
 }
 
@@ -338,7 +580,7 @@ func (h *Handler) ValueHandler(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info(fmt.Sprintf("Headers:  %v", headers))
 
 	if r.Method != http.MethodGet {
-		http.Error(w, "Only Get requests are allowed for value!", http.StatusMethodNotAllowed)
+		h.failMethod(w, r, "Only Get requests are allowed for value!")
 
 		return
 	}
@@ -357,7 +599,7 @@ func (h *Handler) ValueHandler(w http.ResponseWriter, r *http.Request) {
 	case "gauge":
 		val, ok := h.stor.Get(ctx, metric)
 		if !ok {
-			http.Error(w, fmt.Sprintf("%s not found", name), http.StatusNotFound)
+			h.failNotFound(w, r, name)
 
 			return
 		}
@@ -365,87 +607,57 @@ func (h *Handler) ValueHandler(w http.ResponseWriter, r *http.Request) {
 	case "counter":
 		val, ok := h.stor.Get(ctx, metric)
 		if !ok {
-			http.Error(w, fmt.Sprintf("%s not found", name), http.StatusNotFound)
+			h.failNotFound(w, r, name)
 
 			return
 		}
 		w.Write([]byte(strconv.FormatInt(*val.Delta, 10)))
+	case "histogram":
+		val, ok := h.stor.Get(ctx, metric)
+		if !ok {
+			h.failNotFound(w, r, name)
 
-	default:
-		http.Error(w, "wrong type", http.StatusBadRequest)
-
-	}
-
-}
-
-// ShowAllHandler returns html with all known metrics
-func (h *Handler) ShowAllHandler(w http.ResponseWriter, r *http.Request) {
-
-	h.logger.Info("Entered ShowAllHandler")
-
-	if r.Method != http.MethodGet {
-		http.Error(w, "Only Get requests are allowed for value!", http.StatusMethodNotAllowed)
-
-		return
-	}
-	h.logger.Info("method checked")
-
-	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
-	defer cancel()
-
-	gaugeMetrics := []string{}
-	counterMetrics := []string{}
-	for key, val := range h.stor.GetAll(ctx) {
-		if val.MType == "gauge" {
-			var value = 0.0
-			if val.Value != nil {
-				value = *val.Value
-			}
-			h.logger.Info(fmt.Sprintf("trying to add gauge %v", val))
-			gaugeMetrics = append(gaugeMetrics, fmt.Sprintf("<p>%s: %f</p>", key, value))
-		} else if val.MType == "counter" {
-			h.logger.Info(fmt.Sprintf("trying to add counter %v", val))
-			var value int64 = 0
-			if val.Delta != nil {
-				value = *val.Delta
-			}
-			counterMetrics = append(counterMetrics, fmt.Sprintf("<p>%s: %d</p>", key, value))
+			return
 		}
-	}
-	h.logger.Info("Metrics collected")
-
-	html := "<html><body>"
+		body, err := json.Marshal(val)
+		if err != nil {
+			h.failInternal(w, r, "json marhsaling error")
 
-	html += "<h2>Gauge:</h2>"
-	sort.Strings(gaugeMetrics)
-	for _, str := range gaugeMetrics {
-		html += str
-	}
-	html += "<h2>Counter:</h2>"
-	sort.Strings(counterMetrics)
-	for _, str := range counterMetrics {
-		html += str
-	}
-	html += "</body></html>"
-	w.Header().Set("Content-Type", "text/html")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	case "summary":
+		val, ok := h.stor.Get(ctx, metric)
+		if !ok || val.Sketch == nil {
+			h.failNotFound(w, r, name)
 
-	w.Write([]byte(html))
+			return
+		}
+		// single quantile is returned as plain value like gauge
+		if rawQ := r.URL.Query().Get("q"); rawQ != "" {
+			q, err := strconv.ParseFloat(rawQ, 64)
+			if err != nil || q < 0 || q > 1 {
+				h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong quantile", Field: "q"})
 
-}
+				return
+			}
+			w.Write([]byte(strconv.FormatFloat(val.Sketch.Quantile(q), 'f', -1, 64)))
 
-// FaviconHandler returns Gopher!!!!
-func (h *Handler) FaviconHandler(w http.ResponseWriter, r *http.Request) {
+			return
+		}
+		body, err := json.Marshal(val.WithQuantiles())
+		if err != nil {
+			h.failInternal(w, r, "json marhsaling error")
 
-	h.logger.Info("Entered FaviconHandler")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
 
-	icon, err := os.ReadFile("../../images/gopher.png")
-	if err != nil {
-		http.Error(w, "Иконка не найдена", http.StatusNotFound)
+	default:
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidMetric, Message: "wrong type", Field: "type"})
 
-		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-
-	w.Write(icon)
 }
@@ -1,19 +1,33 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Mr-Punder/go-alerting-service/internal/logger"
 	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
 	"github.com/Mr-Punder/go-alerting-service/internal/storage"
 
+	"github.com/golang/snappy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 )
 
 func testRequest(t *testing.T, ts *httptest.Server, method, path string, sentbody string, sentheaders map[string]string) (*http.Response, string) {
@@ -353,11 +367,6 @@ func TestMetricRouter(t *testing.T) {
 			},
 			sentHeaders: map[string]string{},
 			wantHeaders: map[string]string{"Content-Type": "text/html"},
-			wantBody: "<html><body><h2>Gauge:</h2>" +
-				fmt.Sprintf("<p>%s: %f</p>", "gaugeMetric", 1.5) +
-				"<h2>Counter:</h2>" +
-				fmt.Sprintf("<p>%s: %d</p>", "counterMetric", 2) +
-				"</body></html>",
 		},
 		{
 			name:     "wrong request post",
@@ -443,6 +452,29 @@ func TestMetricRouter(t *testing.T) {
 			wantBody:    `{"id":"g","type":"gauge","value":1.5}`,
 			wantHeaders: map[string]string{"Content-Type": "application/json"},
 		},
+		{
+			name:        "get labeled gauge with JSON",
+			method:      http.MethodPost,
+			wantCode:    200,
+			uri:         "/value",
+			sentBody:    `{"id":"g","type":"gauge","labels":{"host":"b"}}`,
+			sentHeaders: map[string]string{"Content-Type": "application/json"},
+			metrics: map[string]metrics.Metrics{
+				"g": {
+					ID:    "g",
+					MType: "gauge",
+					Value: simpleValue(),
+				},
+				`g{host="b"}`: {
+					ID:     "g",
+					MType:  "gauge",
+					Value:  func() *float64 { var v = 7.5; return &v }(),
+					Labels: map[string]string{"host": "b"},
+				},
+			},
+			wantBody:    `{"id":"g","type":"gauge","labels":{"host":"b"},"value":7.5}`,
+			wantHeaders: map[string]string{"Content-Type": "application/json"},
+		},
 		{
 			name:        "new counter with JSON",
 			method:      http.MethodPost,
@@ -627,7 +659,7 @@ func TestMetricRouter(t *testing.T) {
 			stor, err := storage.NewMemStorage(tt.metrics, false, "", Log)
 			require.NoError(t, err)
 
-			ts := httptest.NewServer(NewMetricRouter(stor, Log))
+			ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
 			defer ts.Close()
 
 			resp, body := testRequest(t, ts, tt.method, tt.uri, tt.sentBody, tt.sentHeaders)
@@ -645,3 +677,1458 @@ func TestMetricRouter(t *testing.T) {
 		})
 	}
 }
+
+func TestHistoryHandler(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+	stor.EnableHistory(time.Hour)
+
+	for _, v := range []float64{1, 2, 3} {
+		value := v
+		require.NoError(t, stor.Set(context.Background(), metrics.Metrics{ID: "g", MType: "gauge", Value: &value}))
+	}
+	labeled := 7.0
+	require.NoError(t, stor.Set(context.Background(), metrics.Metrics{ID: "g", MType: "gauge", Value: &labeled, Labels: map[string]string{"host": "a"}}))
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		uri         string
+		wantCode    int
+		wantSamples []float64
+	}{
+		{
+			name:        "all samples",
+			uri:         "/history/gauge/g",
+			wantCode:    http.StatusOK,
+			wantSamples: []float64{1, 2, 3},
+		},
+		{
+			name:     "unknown metric",
+			uri:      "/history/gauge/unknown",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "wrong type",
+			uri:      "/history/smth/g",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "wrong step",
+			uri:      "/history/gauge/g?step=abc",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "too many points",
+			uri:      "/history/gauge/g?step=1ms",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:        "labeled series",
+			uri:         "/history/gauge/g?host=a",
+			wantCode:    http.StatusOK,
+			wantSamples: []float64{7},
+		},
+		{
+			name:     "unknown labels",
+			uri:      "/history/gauge/g?host=b",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:        "empty range",
+			uri:         fmt.Sprintf("/history/gauge/g?from=%d&to=%d", time.Now().Add(-2*time.Hour).Unix(), time.Now().Add(-time.Hour).Unix()),
+			wantCode:    http.StatusOK,
+			wantSamples: []float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, tt.uri, "", map[string]string{})
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var hist struct {
+				Samples []storage.Sample `json:"samples"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(body), &hist))
+			values := make([]float64, 0)
+			for _, s := range hist.Samples {
+				values = append(values, s.Value)
+			}
+			assert.Equal(t, tt.wantSamples, values)
+		})
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+
+	value := func(v float64) *float64 { return &v }
+	delta := func(d int64) *int64 { return &d }
+
+	stor, err := storage.NewMemStorage(map[string]metrics.Metrics{
+		"HeapAlloc":                {ID: "HeapAlloc", MType: "gauge", Value: value(1.5)},
+		`HeapAlloc{host="a"}`:      {ID: "HeapAlloc", MType: "gauge", Value: value(2), Labels: map[string]string{"host": "a"}},
+		"PollCount":                {ID: "PollCount", MType: "counter", Delta: delta(5)},
+		"1st.metric-name":          {ID: "1st.metric-name", MType: "gauge", Value: value(-3)},
+		`Quoted{path="C:\\tmp\""}`: {ID: "Quoted", MType: "gauge", Value: value(0), Labels: map[string]string{"path": `C:\tmp"`}},
+	}, false, "", Log)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics", "", map[string]string{})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	want := "# HELP HeapAlloc gauge HeapAlloc\n" +
+		"# TYPE HeapAlloc gauge\n" +
+		"HeapAlloc 1.5\n" +
+		"HeapAlloc{host=\"a\"} 2\n" +
+		"# HELP PollCount counter PollCount\n" +
+		"# TYPE PollCount counter\n" +
+		"PollCount 5\n" +
+		"# HELP Quoted gauge Quoted\n" +
+		"# TYPE Quoted gauge\n" +
+		"Quoted{path=\"C:\\\\tmp\\\"\"} 0\n" +
+		"# HELP _1st_metric_name gauge 1st.metric-name\n" +
+		"# TYPE _1st_metric_name gauge\n" +
+		"_1st_metric_name -3\n"
+	assert.Equal(t, want, body)
+}
+
+func TestHistogramHandlers(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	jsonHeaders := map[string]string{"Content-Type": "application/json"}
+	tests := []struct {
+		name     string
+		method   string
+		uri      string
+		sentBody string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "new histogram with JSON",
+			method:   http.MethodPost,
+			uri:      "/update",
+			sentBody: `{"id":"latency","type":"histogram","buckets":[{"le":0.1,"count":1},{"le":1,"count":2}],"count":3,"sum":2.6}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"latency","type":"histogram","buckets":[{"le":0.1,"count":1},{"le":1,"count":2}],"count":3,"sum":2.6}`,
+		},
+		{
+			name:     "merge histogram with JSON",
+			method:   http.MethodPost,
+			uri:      "/update",
+			sentBody: `{"id":"latency","type":"histogram","buckets":[{"le":0.1,"count":0},{"le":1,"count":1}],"count":1,"sum":0.5}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"latency","type":"histogram","buckets":[{"le":0.1,"count":1},{"le":1,"count":3}],"count":4,"sum":3.1}`,
+		},
+		{
+			name:     "buckets mismatch",
+			method:   http.MethodPost,
+			uri:      "/update",
+			sentBody: `{"id":"latency","type":"histogram","buckets":[{"le":0.5,"count":1}],"count":1,"sum":0.2}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "not cumulative buckets",
+			method:   http.MethodPost,
+			uri:      "/update",
+			sentBody: `{"id":"latency","type":"histogram","buckets":[{"le":0.1,"count":2},{"le":1,"count":1}],"count":2,"sum":0.2}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "observation by url",
+			method:   http.MethodPost,
+			uri:      "/update/histogram/latency/0.05",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "NaN observation by url",
+			method:   http.MethodPost,
+			uri:      "/update/histogram/latency/NaN",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "infinite observation by url",
+			method:   http.MethodPost,
+			uri:      "/update/histogram/latency/-Inf",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "get histogram",
+			method:   http.MethodGet,
+			uri:      "/value/histogram/latency",
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"latency","type":"histogram","buckets":[{"le":0.1,"count":2},{"le":1,"count":4}],"count":5,"sum":3.15}`,
+		},
+		{
+			name:     "prometheus histogram",
+			method:   http.MethodGet,
+			uri:      "/metrics",
+			wantCode: http.StatusOK,
+			wantBody: "# HELP latency histogram latency\n" +
+				"# TYPE latency histogram\n" +
+				"latency_bucket{le=\"0.1\"} 2\n" +
+				"latency_bucket{le=\"1\"} 4\n" +
+				"latency_bucket{le=\"+Inf\"} 5\n" +
+				"latency_sum 3.15\n" +
+				"latency_count 5\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, tt.method, tt.uri, tt.sentBody, jsonHeaders)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestSummaryHandlers(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	for _, v := range []string{"1", "2", "3"} {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/update/summary/rtt/"+v, "", map[string]string{})
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		uri      string
+		sentBody string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "observation with JSON",
+			method:   http.MethodPost,
+			uri:      "/update",
+			sentBody: `{"id":"rtt","type":"summary","value":4}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "summary without value",
+			method:   http.MethodPost,
+			uri:      "/update",
+			sentBody: `{"id":"rtt","type":"summary"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "get quantile",
+			method:   http.MethodGet,
+			uri:      "/value/summary/rtt?q=0.5",
+			wantCode: http.StatusOK,
+			wantBody: "2.5",
+		},
+		{
+			name:     "wrong quantile",
+			method:   http.MethodGet,
+			uri:      "/value/summary/rtt?q=2",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "unknown summary",
+			method:   http.MethodGet,
+			uri:      "/value/summary/unknown",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, tt.method, tt.uri, tt.sentBody, map[string]string{"Content-Type": "application/json"})
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, body)
+			}
+		})
+	}
+
+	resp, body := testRequest(t, ts, http.MethodPost, "/value", `{"id":"rtt","type":"summary"}`, map[string]string{"Content-Type": "application/json"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var summary metrics.Metrics
+	require.NoError(t, json.Unmarshal([]byte(body), &summary))
+	assert.Equal(t, int64(4), *summary.Count)
+	assert.Equal(t, 10.0, *summary.Sum)
+	assert.Equal(t, map[string]float64{"0.5": 2.5, "0.95": 4, "0.99": 4}, summary.Quantiles)
+}
+
+type testSeries struct {
+	labels  [][2]string
+	samples [][2]float64
+}
+
+// remoteWriteBody encodes series as snappy-compressed WriteRequest
+func remoteWriteBody(series []testSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l[0])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, smp := range s.samples {
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(smp[0]))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(smp[1]))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sample)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return snappy.Encode(nil, req)
+}
+
+func TestRemoteWriteHandler(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	headers := map[string]string{
+		"Content-Type":     "application/x-protobuf",
+		"Content-Encoding": "snappy",
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		want     map[string]string
+	}{
+		{
+			name: "gauges and counters",
+			body: string(remoteWriteBody([]testSeries{
+				{labels: [][2]string{{"__name__", "temperature"}, {"room", "a"}}, samples: [][2]float64{{20.5, 1000}, {21, 2000}}},
+				{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{10, 1000}}},
+				{labels: [][2]string{{"__name__", "stale"}}, samples: [][2]float64{{math.NaN(), 1000}}},
+			})),
+			wantCode: http.StatusNoContent,
+			want: map[string]string{
+				"/value/gauge/temperature":      "",
+				"/value/counter/requests_total": "10",
+				"/value/gauge/stale":            "",
+			},
+		},
+		{
+			name: "counter total grows",
+			body: string(remoteWriteBody([]testSeries{
+				{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{15, 3000}}},
+			})),
+			wantCode: http.StatusNoContent,
+			want:     map[string]string{"/value/counter/requests_total": "15"},
+		},
+		{
+			name: "counter reset",
+			body: string(remoteWriteBody([]testSeries{
+				{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{3, 4000}}},
+			})),
+			wantCode: http.StatusNoContent,
+			want:     map[string]string{"/value/counter/requests_total": "18"},
+		},
+		{
+			name: "counter grows after reset",
+			body: string(remoteWriteBody([]testSeries{
+				{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{5, 5000}}},
+			})),
+			wantCode: http.StatusNoContent,
+			want:     map[string]string{"/value/counter/requests_total": "20"},
+		},
+		{
+			name: "repeated series",
+			body: string(remoteWriteBody([]testSeries{
+				{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{6, 6000}}},
+				{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{8, 7000}}},
+				{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{7, 6500}}},
+			})),
+			wantCode: http.StatusNoContent,
+			want:     map[string]string{"/value/counter/requests_total": "23"},
+		},
+		{
+			name: "infinite samples",
+			body: string(remoteWriteBody([]testSeries{
+				{labels: [][2]string{{"__name__", "hot"}}, samples: [][2]float64{{math.Inf(1), 1000}}},
+				{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{math.Inf(-1), 8000}}},
+			})),
+			wantCode: http.StatusNoContent,
+			want: map[string]string{
+				"/value/gauge/hot":              "",
+				"/value/counter/requests_total": "23",
+			},
+		},
+		{
+			name: "series without name",
+			body: string(remoteWriteBody([]testSeries{
+				{labels: [][2]string{{"job", "a"}}, samples: [][2]float64{{1, 1000}}},
+			})),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "not snappy",
+			body:     "garbage",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := testRequest(t, ts, http.MethodPost, "/api/v1/write", tt.body, headers)
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantCode, resp.StatusCode)
+
+			for uri, want := range tt.want {
+				resp, body := testRequest(t, ts, http.MethodGet, uri, "", map[string]string{})
+				resp.Body.Close()
+				if want == "" {
+					// labeled or skipped series are not found without labels
+					assert.Equal(t, http.StatusNotFound, resp.StatusCode, uri)
+					continue
+				}
+				assert.Equal(t, want, body, uri)
+			}
+		})
+	}
+
+	temperature, ok := stor.Get(context.Background(), metrics.Metrics{ID: "temperature", MType: "gauge", Labels: map[string]string{"room": "a"}})
+	require.True(t, ok)
+	assert.Equal(t, 21.0, *temperature.Value)
+
+}
+
+func TestRemoteWriteStoredCounter(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stored := int64(100)
+	stor, err := storage.NewMemStorage(map[string]metrics.Metrics{
+		"requests_total": {ID: "requests_total", MType: "counter", Delta: &stored},
+	}, false, "", Log)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	headers := map[string]string{"Content-Type": "application/x-protobuf"}
+	// total of counter stored before restart is unknown, first push is a baseline
+	for _, push := range []struct {
+		total float64
+		want  string
+	}{
+		{total: 40, want: "100"},
+		{total: 45, want: "105"},
+	} {
+		body := remoteWriteBody([]testSeries{
+			{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{push.total, 1000}}},
+		})
+		resp, _ := testRequest(t, ts, http.MethodPost, "/api/v1/write", string(body), headers)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, got := testRequest(t, ts, http.MethodGet, "/value/counter/requests_total", "", map[string]string{})
+		resp.Body.Close()
+		assert.Equal(t, push.want, got)
+	}
+}
+
+// barrierStorage holds first two Get calls until both have read or timeout,
+// so two unserialized remote writes read the same stored total
+type barrierStorage struct {
+	*storage.MemStorage
+	calls *int32
+}
+
+func (s barrierStorage) Get(ctx context.Context, metric metrics.Metrics) (metrics.Metrics, bool) {
+	m, ok := s.MemStorage.Get(ctx, metric)
+	atomic.AddInt32(s.calls, 1)
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for atomic.LoadInt32(s.calls) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return m, ok
+}
+
+func TestConcurrentTotals(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		uri         string
+		contentType string
+		body        []byte
+		valueURI    string
+	}{
+		{
+			name:        "remote write",
+			uri:         "/api/v1/write",
+			contentType: "application/x-protobuf",
+			body: remoteWriteBody([]testSeries{
+				{labels: [][2]string{{"__name__", "requests_total"}}, samples: [][2]float64{{7, 1000}}},
+			}),
+			valueURI: "/value/counter/requests_total",
+		},
+		{
+			name:        "otlp",
+			uri:         "/v1/metrics",
+			contentType: "application/json",
+			body:        []byte(`{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"requests","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[{"asInt":"7"}]}}]}]}]}`),
+			valueURI:    "/value/counter/requests",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+			require.NoError(t, err)
+
+			var calls int32
+			ts := httptest.NewServer(NewMetricRouter(barrierStorage{MemStorage: stor, calls: &calls}, nil, Log))
+			defer ts.Close()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					req, err := http.NewRequest(http.MethodPost, ts.URL+tt.uri, bytes.NewReader(tt.body))
+					if !assert.NoError(t, err) {
+						return
+					}
+					req.Header.Set("Content-Type", tt.contentType)
+					resp, err := ts.Client().Do(req)
+					if assert.NoError(t, err) {
+						resp.Body.Close()
+						assert.Less(t, resp.StatusCode, 300)
+					}
+				}()
+			}
+			wg.Wait()
+
+			// every push sent the same total, so it is counted once
+			resp, got := testRequest(t, ts, http.MethodGet, tt.valueURI, "", map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, "7", got)
+		})
+	}
+}
+
+func TestInfluxWriteHandler(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "all lines stored",
+			body:     "requests count=3i\ntemperature value=21.5 1700000000000000000",
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "partial write",
+			body:     "requests count=2i\nbroken",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"stored":1,"errors":[{"line":2,"error":"line must have measurement, fields and optional timestamp"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodPost, "/write", tt.body, map[string]string{"Content-Type": "text/plain"})
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, body)
+			}
+		})
+	}
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/value/counter/requests_count", "", map[string]string{})
+	defer resp.Body.Close()
+	assert.Equal(t, "5", body)
+	resp, body = testRequest(t, ts, http.MethodGet, "/value/gauge/temperature_value", "", map[string]string{})
+	defer resp.Body.Close()
+	assert.Equal(t, "21.5", body)
+}
+
+func TestOTLPHandler(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	req := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{{
+				Name: "requests",
+				Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					IsMonotonic:            true,
+					DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 7}}},
+				}},
+			}}}},
+		}},
+	}
+	protoBody, err := proto.Marshal(req)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		wantCode    int
+		wantTotal   string
+	}{
+		{
+			name:        "protobuf",
+			body:        string(protoBody),
+			contentType: "application/x-protobuf",
+			wantCode:    http.StatusOK,
+			wantTotal:   "7",
+		},
+		{
+			name:        "json cumulative total",
+			body:        `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"requests","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[{"asInt":"12"}]}}]}]}]}`,
+			contentType: "application/json",
+			wantCode:    http.StatusOK,
+			wantTotal:   "12",
+		},
+		{
+			name:        "json counter reset",
+			body:        `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"requests","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[{"asInt":"2"}]}}]}]}]}`,
+			contentType: "application/json",
+			wantCode:    http.StatusOK,
+			wantTotal:   "14",
+		},
+		{
+			name:        "json counter grows after reset",
+			body:        `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"requests","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[{"asInt":"5"}]}}]}]}]}`,
+			contentType: "application/json",
+			wantCode:    http.StatusOK,
+			wantTotal:   "17",
+		},
+		{
+			name:        "wrong json",
+			body:        `{"resourceMetrics":`,
+			contentType: "application/json",
+			wantCode:    http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := testRequest(t, ts, http.MethodPost, "/v1/metrics", tt.body, map[string]string{"Content-Type": tt.contentType})
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantTotal == "" {
+				return
+			}
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+
+			resp, body := testRequest(t, ts, http.MethodGet, "/value/counter/requests", "", map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantTotal, body)
+		})
+	}
+}
+
+func TestMetricsQueryHandler(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	value := 2.5
+	delta := int64(3)
+	require.NoError(t, stor.SetAll(context.Background(), []metrics.Metrics{
+		{ID: "cpu_user", MType: "gauge", Value: &value},
+		{ID: "cpu_system", MType: "gauge", Value: &value},
+		{ID: "requests", MType: "counter", Delta: &delta},
+	}))
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		wantCode int
+		wantIDs  []string
+		wantNext bool
+	}{
+		{
+			name:     "all",
+			url:      "/api/metrics",
+			wantCode: http.StatusOK,
+			wantIDs:  []string{"cpu_system", "cpu_user", "requests"},
+		},
+		{
+			name:     "glob with next page",
+			url:      "/api/metrics?glob=cpu_*&sort=-name&limit=1",
+			wantCode: http.StatusOK,
+			wantIDs:  []string{"cpu_user"},
+			wantNext: true,
+		},
+		{
+			name:     "type and offset",
+			url:      "/api/metrics?type=gauge&offset=1",
+			wantCode: http.StatusOK,
+			wantIDs:  []string{"cpu_user"},
+		},
+		{
+			name:     "wrong regex",
+			url:      "/api/metrics?regex=(",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "wrong limit",
+			url:      "/api/metrics?limit=0",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "wrong cursor",
+			url:      "/api/metrics?cursor=!",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, tt.url, "", map[string]string{})
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var res queryResponse
+			require.NoError(t, json.Unmarshal([]byte(body), &res))
+			ids := make([]string, 0, len(res.Metrics))
+			for _, m := range res.Metrics {
+				ids = append(ids, m.ID)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+			assert.Equal(t, tt.wantNext, res.Next != "")
+		})
+	}
+
+	ids := make([]string, 0)
+	cursor := ""
+	for {
+		resp, body := testRequest(t, ts, http.MethodGet, "/api/metrics?limit=2&cursor="+cursor, "", map[string]string{})
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var res queryResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &res))
+		for _, m := range res.Metrics {
+			ids = append(ids, m.ID)
+		}
+		if res.Next == "" {
+			break
+		}
+		cursor = res.Next
+	}
+	assert.Equal(t, []string{"cpu_system", "cpu_user", "requests"}, ids)
+}
+
+func TestRangeHandler(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+	stor.EnableHistory(time.Hour)
+
+	ctx := context.Background()
+	for _, v := range []float64{1, 2, 6} {
+		value := v
+		require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "g", MType: "gauge", Value: &value}))
+	}
+	for _, d := range []int64{4, 4} {
+		delta := d
+		require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "c", MType: "counter", Delta: &delta}))
+	}
+	require.NoError(t, stor.Delete(ctx, metrics.Metrics{ID: "c", MType: "counter"}))
+	delta := int64(10)
+	require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "c", MType: "counter", Delta: &delta}))
+	labeled := 9.0
+	require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "g", MType: "gauge", Value: &labeled, Labels: map[string]string{"host": "a"}}))
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		uri        string
+		wantCode   int
+		wantPoints []float64
+	}{
+		{
+			name:       "labeled series",
+			uri:        "/query/gauge/g?fn=avg&host=a",
+			wantCode:   http.StatusOK,
+			wantPoints: []float64{9},
+		},
+		{
+			name:       "avg of gauge",
+			uri:        "/query/gauge/g?fn=avg",
+			wantCode:   http.StatusOK,
+			wantPoints: []float64{3},
+		},
+		{
+			name:       "max per step",
+			uri:        fmt.Sprintf("/query/gauge/g?fn=max&from=%d&to=%d&step=30m", time.Now().Add(-time.Hour).Unix()+1, time.Now().Unix()+1),
+			wantCode:   http.StatusOK,
+			wantPoints: []float64{6},
+		},
+		{
+			name:       "increase over counter reset",
+			uri:        "/query/counter/c?fn=increase",
+			wantCode:   http.StatusOK,
+			wantPoints: []float64{14},
+		},
+		{
+			name:     "rate of gauge",
+			uri:      "/query/gauge/g?fn=rate",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "wrong function",
+			uri:      "/query/gauge/g?fn=median",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "wrong window",
+			uri:      "/query/gauge/g?window=0s",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "too many points",
+			uri:      "/query/gauge/g?step=1ns",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "unknown metric",
+			uri:      "/query/counter/unknown",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, tt.uri, "", map[string]string{})
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Points []storage.Sample `json:"points"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(body), &res))
+			values := make([]float64, 0)
+			for _, s := range res.Points {
+				values = append(values, s.Value)
+			}
+			assert.Equal(t, tt.wantPoints, values)
+		})
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	value := 1.5
+	require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "cpu_user", MType: "gauge", Value: &value}))
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodGet, "/stream?regex=(", "", map[string]string{})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/stream?glob=cpu_*", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() string {
+		for events.Scan() {
+			if line := events.Text(); strings.HasPrefix(line, "data: ") {
+				return strings.TrimPrefix(line, "data: ")
+			}
+		}
+		return ""
+	}
+
+	assert.JSONEq(t, `{"id":"cpu_user","type":"gauge","value":1.5}`, next())
+
+	delta := int64(2)
+	require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "requests", MType: "counter", Delta: &delta}))
+	require.NoError(t, stor.SetAll(ctx, []metrics.Metrics{
+		{ID: "cpu_system", MType: "gauge", Value: &value},
+		{ID: "requests", MType: "counter", Delta: &delta},
+	}))
+	assert.JSONEq(t, `{"id":"cpu_system","type":"gauge","value":1.5}`, next())
+
+	stor.StopNotifications()
+	assert.Equal(t, "", next())
+}
+
+func TestDashboard(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+	stor.EnableHistory(time.Hour)
+
+	ctx := context.Background()
+	for _, v := range []float64{1, 3, 2} {
+		value := v
+		require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "cpu", MType: "gauge", Value: &value, Labels: map[string]string{"host": "a"}}))
+	}
+	delta := int64(7)
+	require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "<requests>", MType: "counter", Delta: &delta}))
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		uri         string
+		wantCode    int
+		wantType    string
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:     "table",
+			uri:      "/",
+			wantCode: http.StatusOK,
+			wantType: "text/html",
+			contains: []string{
+				`<a href="/ui/gauge/cpu?host=a">cpu</a>`,
+				`<td class="labels">host=&#34;a&#34;</td>`,
+				`<td class="value">2</td>`,
+				`&lt;requests&gt;`,
+				`<td class="value">7</td>`,
+			},
+		},
+		{
+			name:        "search",
+			uri:         "/?q=HOST",
+			wantCode:    http.StatusOK,
+			contains:    []string{`cpu</a>`},
+			notContains: []string{`&lt;requests&gt;`},
+		},
+		{
+			name:     "metric page",
+			uri:      "/ui/gauge/cpu?host=a",
+			wantCode: http.StatusOK,
+			wantType: "text/html",
+			contains: []string{
+				`data-samples="[1,3,2]"`,
+				`<polyline points="0.0,120.0 300.0,0.0 600.0,60.0"></polyline>`,
+				`Last hour, 3 samples`,
+			},
+		},
+		{
+			name:     "metric page without labels",
+			uri:      "/ui/gauge/cpu",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "static",
+			uri:      "/static/dashboard.js",
+			wantCode: http.StatusOK,
+			contains: []string{"fetch('/value/'"},
+		},
+		{
+			name:     "favicon",
+			uri:      "/favicon.ico",
+			wantCode: http.StatusOK,
+			wantType: "image/png",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, tt.uri, "", map[string]string{})
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantType != "" {
+				assert.Equal(t, tt.wantType, resp.Header.Get("Content-Type"))
+			}
+			for _, want := range tt.contains {
+				assert.Contains(t, body, want)
+			}
+			for _, unwanted := range tt.notContains {
+				assert.NotContains(t, body, unwanted)
+			}
+		})
+	}
+
+	// row refresh request of dashboard.js
+	resp, body := testRequest(t, ts, http.MethodPost, "/value/", `{"id":"cpu","type":"gauge","labels":{"host":"a"}}`, map[string]string{"Content-Type": "application/json"})
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"id":"cpu","type":"gauge","labels":{"host":"a"},"value":2}`, body)
+}
+
+func TestAPIv1Errors(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	json := map[string]string{"Content-Type": "application/json"}
+	tests := []struct {
+		name     string
+		method   string
+		uri      string
+		body     string
+		wantCode int
+		wantBody string
+		wantType string
+	}{
+		{
+			name:     "update",
+			method:   http.MethodPost,
+			uri:      "/api/v1/update",
+			body:     `{"id":"g","type":"gauge","value":1.5}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"g","type":"gauge","value":1.5}`,
+			wantType: "application/json",
+		},
+		{
+			name:     "broken otlp json",
+			method:   http.MethodPost,
+			uri:      "/api/v1/otlp/v1/metrics",
+			body:     `{"resourceMetrics":`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":{"code":"bad_request","message":"wrong requests"}}`,
+			wantType: "application/json",
+		},
+		{
+			name:     "broken json",
+			method:   http.MethodPost,
+			uri:      "/api/v1/update",
+			body:     `{"id":`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":{"code":"invalid_json","message":"wrong requests"}}`,
+			wantType: "application/json",
+		},
+		{
+			name:     "invalid metric field",
+			method:   http.MethodPost,
+			uri:      "/api/v1/updates",
+			body:     `[{"id":"h","type":"histogram","sum":1}]`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":{"code":"invalid_metric","message":"histogram without count","field":"count"}}`,
+		},
+		{
+			name:     "wrong type in path",
+			method:   http.MethodPost,
+			uri:      "/api/v1/update/smth/x/1",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":{"code":"invalid_metric","message":"wrong type","field":"type"}}`,
+		},
+		{
+			name:     "wrong value in path",
+			method:   http.MethodPost,
+			uri:      "/api/v1/update/counter/x/1.5",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":{"code":"invalid_metric","message":"wrong format value","field":"value"}}`,
+		},
+		{
+			name:     "unknown metric",
+			method:   http.MethodGet,
+			uri:      "/api/v1/value/gauge/unknown",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"error":{"code":"not_found","message":"unknown not found"}}`,
+		},
+		{
+			name:     "wrong query parameter",
+			method:   http.MethodGet,
+			uri:      "/api/v1/metrics?limit=0",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":{"code":"invalid_param","message":"wrong limit 0","field":"limit"}}`,
+		},
+		{
+			name:     "history disabled",
+			method:   http.MethodGet,
+			uri:      "/api/v1/history/gauge/g",
+			wantCode: http.StatusNotImplemented,
+			wantBody: `{"error":{"code":"not_implemented","message":"history is not supported"}}`,
+		},
+		{
+			name:     "unknown endpoint",
+			method:   http.MethodGet,
+			uri:      "/api/v1/nothing",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"error":{"code":"not_found","message":"no such endpoint"}}`,
+		},
+		{
+			name:     "wrong method",
+			method:   http.MethodDelete,
+			uri:      "/api/v1/updates",
+			wantCode: http.StatusMethodNotAllowed,
+			wantBody: `{"error":{"code":"method_not_allowed","message":"DELETE is not allowed for /api/v1/updates"}}`,
+		},
+		{
+			name:     "unversioned route keeps plain text",
+			method:   http.MethodGet,
+			uri:      "/value/gauge/unknown",
+			wantCode: http.StatusNotFound,
+			wantBody: "unknown not found\n",
+			wantType: "text/plain; charset=utf-8",
+		},
+		{
+			name:     "unversioned broken json",
+			method:   http.MethodPost,
+			uri:      "/update/",
+			body:     `{"id":`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "wrong requests\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, tt.method, tt.uri, tt.body, json)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			assert.Equal(t, tt.wantBody, body)
+			if tt.wantType != "" {
+				assert.Equal(t, tt.wantType, resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestBatchModes(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	json := map[string]string{"Content-Type": "application/json"}
+	tests := []struct {
+		name     string
+		uri      string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "partial all stored",
+			uri:      "/updates/?mode=partial",
+			body:     `[{"id":"c","type":"counter","delta":2},{"id":"c","type":"counter","delta":3}]`,
+			wantCode: http.StatusOK,
+			wantBody: `[{"index":0,"id":"c","type":"counter","status":"stored","metric":{"id":"c","type":"counter","delta":2}},` +
+				`{"index":1,"id":"c","type":"counter","status":"stored","metric":{"id":"c","type":"counter","delta":5}}]`,
+		},
+		{
+			name:     "partial with wrong metrics",
+			uri:      "/updates/?mode=partial",
+			body:     `[{"id":"x","type":"smth","value":1},{"id":"g","type":"gauge","value":1.5},{"id":"c","type":"gauge"}]`,
+			wantCode: http.StatusMultiStatus,
+			wantBody: `[{"index":0,"id":"x","type":"smth","status":"invalid","error":{"code":"invalid_metric","message":"wrong type smth","field":"type"}},` +
+				`{"index":1,"id":"g","type":"gauge","status":"stored","metric":{"id":"g","type":"gauge","value":1.5}},` +
+				`{"index":2,"id":"c","type":"gauge","status":"invalid","error":{"code":"invalid_metric","message":"gauge without value","field":"value"}}]`,
+		},
+		{
+			name:     "partial with type conflict",
+			uri:      "/api/v1/updates?mode=partial",
+			body:     `[{"id":"g","type":"counter","delta":1},{"id":"c","type":"counter","delta":1}]`,
+			wantCode: http.StatusMultiStatus,
+			wantBody: `[{"index":0,"id":"g","type":"counter","status":"failed","error":{"code":"type_conflict","message":"wrong type"}},` +
+				`{"index":1,"id":"c","type":"counter","status":"stored","metric":{"id":"c","type":"counter","delta":6}}]`,
+		},
+		{
+			name:     "atomic rejected by type conflict",
+			uri:      "/updates/?mode=atomic",
+			body:     `[{"id":"c","type":"counter","delta":10},{"id":"g","type":"counter","delta":1}]`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `[{"index":0,"id":"c","type":"counter","status":"skipped"},` +
+				`{"index":1,"id":"g","type":"counter","status":"failed","error":{"code":"type_conflict","message":"wrong type"}}]`,
+		},
+		{
+			name:     "atomic rejected by validation",
+			uri:      "/updates/?mode=atomic",
+			body:     `[{"id":"c","type":"counter","delta":10},{"id":"c","type":"counter"}]`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `[{"index":0,"id":"c","type":"counter","status":"skipped"},` +
+				`{"index":1,"id":"c","type":"counter","status":"invalid","error":{"code":"invalid_metric","message":"counter without delta","field":"delta"}}]`,
+		},
+		{
+			name:     "atomic stored",
+			uri:      "/updates/?mode=atomic",
+			body:     `[{"id":"c","type":"counter","delta":4},{"id":"g","type":"gauge","value":2}]`,
+			wantCode: http.StatusOK,
+			wantBody: `[{"index":0,"id":"c","type":"counter","status":"stored","metric":{"id":"c","type":"counter","delta":10}},` +
+				`{"index":1,"id":"g","type":"gauge","status":"stored","metric":{"id":"g","type":"gauge","value":2}}]`,
+		},
+		{
+			name:     "wrong mode",
+			uri:      "/api/v1/updates?mode=some",
+			body:     `[]`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":{"code":"invalid_param","message":"wrong mode some","field":"mode"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodPost, tt.uri, tt.body, json)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			assert.Equal(t, tt.wantBody, strings.TrimSpace(body))
+		})
+	}
+}
+
+// brokenStorage fails to store any metric with err
+type brokenStorage struct {
+	*storage.MemStorage
+	err error
+}
+
+func (s brokenStorage) Set(ctx context.Context, metric metrics.Metrics) error {
+	return s.err
+}
+
+func (s brokenStorage) SetAll(ctx context.Context, batch []metrics.Metrics) error {
+	return s.err
+}
+
+func (s brokenStorage) SetAtomic(ctx context.Context, batch []metrics.Metrics) ([]metrics.Metrics, error) {
+	return nil, s.err
+}
+
+func TestStorageFailure(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	json := map[string]string{"Content-Type": "application/json"}
+	tests := []struct {
+		name     string
+		err      error
+		uri      string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "update",
+			err:      errors.New("connection refused"),
+			uri:      "/api/v1/update/gauge/g/1",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"error":{"code":"storage_error","message":"Cann't store metrics"}}`,
+		},
+		{
+			name:     "updates",
+			err:      errors.New("connection refused"),
+			uri:      "/api/v1/updates",
+			body:     `[{"id":"g","type":"gauge","value":1}]`,
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"error":{"code":"storage_error","message":"Cann't store metrics"}}`,
+		},
+		{
+			name:     "updates timed out",
+			err:      context.DeadlineExceeded,
+			uri:      "/api/v1/updates",
+			body:     `[{"id":"g","type":"gauge","value":1}]`,
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"error":{"code":"storage_error","message":"storage timed out"}}`,
+		},
+		{
+			name:     "otlp",
+			err:      errors.New("connection refused"),
+			uri:      "/api/v1/otlp/v1/metrics",
+			body:     `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"g","gauge":{"dataPoints":[{"asDouble":1}]}}]}]}]}`,
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"error":{"code":"storage_error","message":"Cann't store metrics"}}`,
+		},
+		{
+			name:     "atomic updates",
+			err:      errors.New("connection refused"),
+			uri:      "/api/v1/updates?mode=atomic",
+			body:     `[{"id":"g","type":"gauge","value":1}]`,
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"error":{"code":"storage_error","message":"Cann't store metrics"}}`,
+		},
+		{
+			name:     "atomic updates failed on metric",
+			err:      &storage.BatchError{Index: 0, Err: errors.New("connection refused")},
+			uri:      "/api/v1/updates?mode=atomic",
+			body:     `[{"id":"g","type":"gauge","value":1}]`,
+			wantCode: http.StatusInternalServerError,
+			wantBody: `[{"index":0,"id":"g","type":"gauge","status":"failed","error":{"code":"storage_error","message":"Cann't store metrics"}}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(NewMetricRouter(brokenStorage{MemStorage: stor, err: tt.err}, nil, Log))
+			defer ts.Close()
+
+			resp, body := testRequest(t, ts, http.MethodPost, tt.uri, tt.body, json)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			assert.Equal(t, tt.wantBody, strings.TrimSpace(body))
+		})
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+	stor.EnableIdempotency(time.Minute)
+
+	ts := httptest.NewServer(NewMetricRouter(stor, nil, Log))
+	defer ts.Close()
+
+	batch := `[{"id":"PollCount","type":"counter","delta":2}]`
+	tests := []struct {
+		name         string
+		uri          string
+		key          string
+		body         string
+		wantCode     int
+		wantReplayed string
+		wantBody     string
+		wantCount    string
+	}{
+		{
+			name:      "first attempt",
+			uri:       "/updates/",
+			key:       "k1",
+			body:      batch,
+			wantCode:  http.StatusOK,
+			wantCount: "2",
+		},
+		{
+			name:         "retry",
+			uri:          "/updates/",
+			key:          "k1",
+			body:         batch,
+			wantCode:     http.StatusOK,
+			wantReplayed: "true",
+			wantCount:    "2",
+		},
+		{
+			name:      "new key",
+			uri:       "/api/v1/updates",
+			key:       "k2",
+			body:      batch,
+			wantCode:  http.StatusOK,
+			wantCount: "4",
+		},
+		{
+			name:      "without key",
+			uri:       "/updates/",
+			body:      batch,
+			wantCode:  http.StatusOK,
+			wantCount: "6",
+		},
+		{
+			name:      "key is too long",
+			uri:       "/api/v1/updates",
+			key:       strings.Repeat("k", 65),
+			body:      batch,
+			wantCode:  http.StatusBadRequest,
+			wantBody:  `{"error":{"code":"invalid_param","message":"idempotency key is too long","field":"Idempotency-Key"}}`,
+			wantCount: "6",
+		},
+		{
+			name:      "key with mode",
+			uri:       "/api/v1/updates?mode=atomic",
+			key:       "k3",
+			body:      batch,
+			wantCode:  http.StatusBadRequest,
+			wantBody:  `{"error":{"code":"invalid_param","message":"idempotency key is not supported in mode atomic","field":"Idempotency-Key"}}`,
+			wantCount: "6",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{"Content-Type": "application/json"}
+			if tt.key != "" {
+				headers[IdempotencyKeyHeader] = tt.key
+			}
+			resp, body := testRequest(t, ts, http.MethodPost, tt.uri, tt.body, headers)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			assert.Equal(t, tt.wantReplayed, resp.Header.Get(IdempotentReplayedHeader))
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, strings.TrimSpace(body))
+			}
+
+			resp, body = testRequest(t, ts, http.MethodGet, "/value/counter/PollCount", "", map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantCount, body)
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// historyResponse is answer of HistoryHandler
+type historyResponse struct {
+	ID      string            `json:"id"`
+	MType   string            `json:"type"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	From    time.Time         `json:"from"`
+	To      time.Time         `json:"to"`
+	Step    string            `json:"step,omitempty"`
+	Samples []storage.Sample  `json:"samples"`
+}
+
+// HistoryHandler returns samples of metric in range ?from=&to=, evenly stepped if ?step= is set.
+// Other query parameters are labels of metric
+func (h *Handler) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered HistoryHandler")
+
+	if r.Method != http.MethodGet {
+		h.failMethod(w, r, "Only GET requests are allowed for history!")
+
+		return
+	}
+
+	historian, ok := h.stor.(storage.MetricsHistorian)
+	if !ok {
+		h.logger.Error("storage doesn't keep history")
+		h.fail(w, r, http.StatusNotImplemented, APIError{Code: CodeNotImplemented, Message: "history is not supported"})
+
+		return
+	}
+
+	tp := chi.URLParam(r, "type")
+	name := chi.URLParam(r, "name")
+	if tp != "gauge" && tp != "counter" {
+		h.logger.Error(fmt.Sprintf("wrong type %s", tp))
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong type", Field: "type"})
+
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-time.Hour)
+	var step time.Duration
+	var err error
+
+	if raw := query.Get("to"); raw != "" {
+		if to, err = parseTime(raw); err != nil {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong to", Field: "to"})
+
+			return
+		}
+	}
+	if raw := query.Get("from"); raw != "" {
+		if from, err = parseTime(raw); err != nil {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong from", Field: "from"})
+
+			return
+		}
+	}
+	if raw := query.Get("step"); raw != "" {
+		if step, err = time.ParseDuration(raw); err != nil || step <= 0 {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong step", Field: "step"})
+
+			return
+		}
+	}
+	if from.After(to) {
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "from is after to", Field: "from"})
+
+		return
+	}
+	if err := checkPoints(from, to, step); err != nil {
+		h.failInvalid(w, r, CodeInvalidParam, err)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	metric := metrics.Metrics{ID: name, MType: tp, Labels: queryLabels(r, "from", "to", "step")}
+	if _, ok := h.stor.Get(ctx, metric); !ok {
+		h.failNotFound(w, r, name)
+
+		return
+	}
+
+	samples, err := historian.History(ctx, metric, from, to)
+	if err != nil {
+		h.logger.Errorf("Cann't get history of %s %s", name, err)
+		h.failHistory(w, r, err)
+
+		return
+	}
+	if step > 0 {
+		samples = storage.Downsample(samples, from, to, step)
+	}
+
+	resp := historyResponse{
+		ID:      name,
+		MType:   tp,
+		Labels:  metric.Labels,
+		From:    from,
+		To:      to,
+		Samples: samples,
+	}
+	if step > 0 {
+		resp.Step = step.String()
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		h.logger.Error("json marhsaling error")
+		h.failInternal(w, r, "json marhsaling error")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+	h.logger.Info("HistoryHandler exited")
+}
+
+// rangeResponse is answer of RangeHandler
+type rangeResponse struct {
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Func   string            `json:"fn"`
+	From   time.Time         `json:"from"`
+	To     time.Time         `json:"to"`
+	Step   string            `json:"step,omitempty"`
+	Window string            `json:"window"`
+	Points []storage.Sample  `json:"points"`
+}
+
+// RangeHandler evaluates ?fn= over history of metric in range ?from=&to=.
+// Query parameters other than these are labels of metric.
+// With ?step= every point covers ?window= (step by default) before it,
+// without it one point at `to` covers whole range
+func (h *Handler) RangeHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered RangeHandler")
+
+	if r.Method != http.MethodGet {
+		h.failMethod(w, r, "Only GET requests are allowed for range query!")
+
+		return
+	}
+
+	historian, ok := h.stor.(storage.MetricsHistorian)
+	if !ok {
+		h.logger.Error("storage doesn't keep history")
+		h.fail(w, r, http.StatusNotImplemented, APIError{Code: CodeNotImplemented, Message: "history is not supported"})
+
+		return
+	}
+
+	tp := chi.URLParam(r, "type")
+	name := chi.URLParam(r, "name")
+	if !metrics.ValidType(tp) {
+		h.logger.Error(fmt.Sprintf("wrong type %s", tp))
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong type", Field: "type"})
+
+		return
+	}
+
+	query := r.URL.Query()
+	fn := query.Get("fn")
+	if fn == "" {
+		fn = storage.FuncLast
+	}
+	if err := storage.ValidFunc(fn, tp); err != nil {
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: err.Error(), Field: "fn"})
+
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-time.Hour)
+	var step, window time.Duration
+	var err error
+
+	if raw := query.Get("to"); raw != "" {
+		if to, err = parseTime(raw); err != nil {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong to", Field: "to"})
+
+			return
+		}
+	}
+	if raw := query.Get("from"); raw != "" {
+		if from, err = parseTime(raw); err != nil {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong from", Field: "from"})
+
+			return
+		}
+	}
+	if raw := query.Get("step"); raw != "" {
+		if step, err = time.ParseDuration(raw); err != nil || step <= 0 {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong step", Field: "step"})
+
+			return
+		}
+	}
+	if raw := query.Get("window"); raw != "" {
+		if window, err = time.ParseDuration(raw); err != nil || window <= 0 {
+			h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "wrong window", Field: "window"})
+
+			return
+		}
+	}
+	if from.After(to) {
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeInvalidParam, Message: "from is after to", Field: "from"})
+
+		return
+	}
+	if err := checkPoints(from, to, step); err != nil {
+		h.failInvalid(w, r, CodeInvalidParam, err)
+
+		return
+	}
+	if window == 0 {
+		window = step
+		if step == 0 {
+			window = to.Sub(from) + time.Nanosecond
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	metric := metrics.Metrics{ID: name, MType: tp, Labels: queryLabels(r, "fn", "from", "to", "step", "window")}
+	if _, ok := h.stor.Get(ctx, metric); !ok {
+		h.failNotFound(w, r, name)
+
+		return
+	}
+
+	// one more window gives baseline of rate and increase for the first point
+	samples, err := historian.History(ctx, metric, from.Add(-2*window), to)
+	if err != nil {
+		h.logger.Errorf("Cann't get history of %s %s", name, err)
+		h.failHistory(w, r, err)
+
+		return
+	}
+
+	points, err := storage.Evaluate(ctx, samples, fn, from, to, step, window)
+	if err != nil {
+		h.logger.Errorf("Cann't evaluate %s of %s %s", fn, name, err)
+		h.fail(w, r, http.StatusServiceUnavailable, APIError{Code: CodeInternal, Message: "range query timed out"})
+
+		return
+	}
+
+	resp := rangeResponse{
+		ID:     name,
+		MType:  tp,
+		Labels: metric.Labels,
+		Func:   fn,
+		From:   from,
+		To:     to,
+		Window: window.String(),
+		Points: points,
+	}
+	if step > 0 {
+		resp.Step = step.String()
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		h.logger.Error("json marhsaling error")
+		h.failInternal(w, r, "json marhsaling error")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+	h.logger.Info("RangeHandler exited")
+}
+
+// queryLabels returns query parameters except reserved ones as labels of metric
+func queryLabels(r *http.Request, reserved ...string) map[string]string {
+	skip := make(map[string]bool, len(reserved))
+	for _, k := range reserved {
+		skip[k] = true
+	}
+
+	var labels map[string]string
+	query := r.URL.Query()
+	for k := range query {
+		if skip[k] {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[k] = query.Get(k)
+	}
+	return labels
+}
+
+// maxPoints limits number of points of stepped history and range queries
+const maxPoints = 11000
+
+// checkPoints rejects step that gives more than maxPoints from `from` to `to`
+func checkPoints(from, to time.Time, step time.Duration) error {
+	if step > 0 && to.Sub(from)/step >= maxPoints {
+		return &metrics.FieldError{Field: "step", Message: fmt.Sprintf("step gives more than %d points", maxPoints)}
+	}
+	return nil
+}
+
+// failHistory answers to error of MetricsHistorian
+func (h *Handler) failHistory(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, storage.ErrHistoryDisabled) {
+		h.fail(w, r, http.StatusNotImplemented, APIError{Code: CodeNotImplemented, Message: "history is not supported"})
+
+		return
+	}
+	h.fail(w, r, http.StatusInternalServerError, APIError{Code: CodeStorage, Message: "cann't get history"})
+}
+
+// parseTime accepts unix seconds or RFC3339 time
+func parseTime(raw string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/ingest"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLPHandler accepts OTLP/HTTP ExportMetricsServiceRequest in protobuf or JSON encoding
+func (h *Handler) OTLPHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered OTLPHandler")
+
+	if r.Method != http.MethodPost {
+		h.failMethod(w, r, "Only POST requests are allowed for metrics!")
+
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.logger.Errorf("Can not read request body %s", err)
+		h.failInternal(w, r, "Can not read request body")
+
+		return
+	}
+
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	if isJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, req)
+	} else {
+		err = proto.Unmarshal(body, req)
+	}
+	if err != nil {
+		h.logger.Errorf("otlp decoding error %s", err)
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "wrong requests"})
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	// increments depend on previous points and stored values, so concurrent exports must not interleave
+	h.otlpMu.Lock()
+	defer h.otlpMu.Unlock()
+
+	batch, points := ingest.ConvertOTLP(req, func(metric metrics.Metrics) (metrics.Metrics, bool) {
+		return h.stor.Get(ctx, metric)
+	}, h.otlpTotals)
+	if len(batch) > 0 {
+		if err := h.storeAll(ctx, batch); err != nil {
+			h.failStore(w, r, err)
+
+			return
+		}
+	}
+	h.otlpTotals.Update(points)
+	h.logger.Infof("Stored %d otlp data points", len(batch))
+
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	var respBody []byte
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		respBody, err = protojson.Marshal(resp)
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		respBody, err = proto.Marshal(resp)
+	}
+	if err != nil {
+		h.logger.Error("otlp response marshaling error")
+		h.failInternal(w, r, "otlp response marshaling error")
+
+		return
+	}
+	w.Write(respBody)
+	h.logger.Info("OTLPHandler exited")
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// PrometheusHandler returns all metrics in Prometheus text exposition format
+func (h *Handler) PrometheusHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered PrometheusHandler")
+
+	if r.Method != http.MethodGet {
+		h.failMethod(w, r, "Only GET requests are allowed for metrics!")
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	// series with the same name must be grouped under one TYPE line
+	families := make(map[string][]metrics.Metrics)
+	for _, metric := range h.stor.GetAll(ctx) {
+		if !metrics.ValidType(metric.MType) {
+			continue
+		}
+		name := promName(metric.ID)
+		families[name] = append(families[name], metric)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		series := families[name]
+		sort.Slice(series, func(i, j int) bool { return series[i].Key() < series[j].Key() })
+
+		mtype := series[0].MType
+		b.WriteString("# HELP " + name + " " + mtype + " " + series[0].ID + "\n")
+		b.WriteString("# TYPE " + name + " " + mtype + "\n")
+		for _, metric := range series {
+			// the same name can't have two types, first one wins
+			if metric.MType != mtype {
+				h.logger.Errorf("Metric %s has type %s, expected %s", metric.Key(), metric.MType, mtype)
+				continue
+			}
+			if metric.MType == "histogram" {
+				writePromHistogram(&b, name, metric)
+				continue
+			}
+			if metric.MType == "summary" {
+				writePromSummary(&b, name, metric)
+				continue
+			}
+			b.WriteString(name)
+			b.WriteString(promLabels(metric.Labels))
+			b.WriteByte(' ')
+			if metric.MType == "gauge" {
+				var value float64
+				if metric.Value != nil {
+					value = *metric.Value
+				}
+				b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+			} else {
+				var delta int64
+				if metric.Delta != nil {
+					delta = *metric.Delta
+				}
+				b.WriteString(strconv.FormatInt(delta, 10))
+			}
+			b.WriteByte('\n')
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+	h.logger.Info("PrometheusHandler exited")
+}
+
+// writePromHistogram writes _bucket series with +Inf bucket, _sum and _count of histogram
+func writePromHistogram(b *strings.Builder, name string, metric metrics.Metrics) {
+	var count int64
+	var sum float64
+	if metric.Count != nil {
+		count = *metric.Count
+	}
+	if metric.Sum != nil {
+		sum = *metric.Sum
+	}
+
+	bucketLabels := make(map[string]string, len(metric.Labels)+1)
+	for k, v := range metric.Labels {
+		bucketLabels[k] = v
+	}
+	buckets := make([]metrics.Bucket, 0, len(metric.Buckets)+1)
+	buckets = append(buckets, metric.Buckets...)
+	buckets = append(buckets, metrics.Bucket{Le: math.Inf(1), Count: count})
+	for i, bucket := range buckets {
+		// explicit +Inf bucket is written once
+		if math.IsInf(bucket.Le, 1) && i != len(buckets)-1 {
+			continue
+		}
+		bucketLabels["le"] = strconv.FormatFloat(bucket.Le, 'g', -1, 64)
+		b.WriteString(name + "_bucket" + promLabels(bucketLabels) + " " + strconv.FormatInt(bucket.Count, 10) + "\n")
+	}
+
+	labels := promLabels(metric.Labels)
+	b.WriteString(name + "_sum" + labels + " " + strconv.FormatFloat(sum, 'g', -1, 64) + "\n")
+	b.WriteString(name + "_count" + labels + " " + strconv.FormatInt(count, 10) + "\n")
+}
+
+// writePromSummary writes DefaultQuantiles series, _sum and _count of summary
+func writePromSummary(b *strings.Builder, name string, metric metrics.Metrics) {
+	var count int64
+	var sum float64
+	if metric.Count != nil {
+		count = *metric.Count
+	}
+	if metric.Sum != nil {
+		sum = *metric.Sum
+	}
+
+	if metric.Sketch != nil && len(metric.Sketch.Centroids) > 0 {
+		quantileLabels := make(map[string]string, len(metric.Labels)+1)
+		for k, v := range metric.Labels {
+			quantileLabels[k] = v
+		}
+		for _, q := range metrics.DefaultQuantiles {
+			quantileLabels["quantile"] = strconv.FormatFloat(q, 'g', -1, 64)
+			b.WriteString(name + promLabels(quantileLabels) + " " + strconv.FormatFloat(metric.Sketch.Quantile(q), 'g', -1, 64) + "\n")
+		}
+	}
+
+	labels := promLabels(metric.Labels)
+	b.WriteString(name + "_sum" + labels + " " + strconv.FormatFloat(sum, 'g', -1, 64) + "\n")
+	b.WriteString(name + "_count" + labels + " " + strconv.FormatInt(count, 10) + "\n")
+}
+
+// promName replaces characters not allowed in Prometheus metric name with underscore
+func promName(id string) string {
+	var b strings.Builder
+	for i, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		// label names can't contain colons
+		labelName := strings.ReplaceAll(promName(name), ":", "_")
+		parts = append(parts, labelName+`="`+replacer.Replace(labels[name])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
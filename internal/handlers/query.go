@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// queryResponse is answer of MetricsQueryHandler, Next is cursor of the next page
+type queryResponse struct {
+	Metrics []metrics.Metrics `json:"metrics"`
+	Next    string            `json:"next,omitempty"`
+}
+
+// MetricsQueryHandler lists metrics filtered by ?type=&prefix=&glob=&regex=,
+// sorted by ?sort= and paged by ?limit= with ?offset= or ?cursor=
+func (h *Handler) MetricsQueryHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered MetricsQueryHandler")
+
+	if r.Method != http.MethodGet {
+		h.failMethod(w, r, "Only GET requests are allowed for metrics query!")
+
+		return
+	}
+
+	filter, err := parseFilter(r)
+	if err == nil {
+		err = filter.Validate()
+	}
+	if err != nil {
+		h.logger.Errorf("wrong metrics query %s", err)
+		h.failInvalid(w, r, CodeInvalidParam, err)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	// one more metric shows whether there is next page
+	limit := filter.Limit
+	filter.Limit++
+	var found []metrics.Metrics
+	if querier, ok := h.stor.(storage.MetricsQuerier); ok {
+		found, err = querier.Query(ctx, filter)
+	} else {
+		found, err = storage.FilterMetrics(h.stor.GetAll(ctx), filter)
+	}
+	if err != nil {
+		h.logger.Errorf("Cann't query metrics %s", err)
+		h.fail(w, r, http.StatusInternalServerError, APIError{Code: CodeStorage, Message: "cann't query metrics"})
+
+		return
+	}
+
+	resp := queryResponse{Metrics: make([]metrics.Metrics, 0, len(found))}
+	if len(found) > limit {
+		found = found[:limit]
+		body, err := json.Marshal(storage.CursorOf(found[limit-1]))
+		if err != nil {
+			h.logger.Error("json marhsaling error")
+			h.failInternal(w, r, "json marhsaling error")
+
+			return
+		}
+		resp.Next = base64.RawURLEncoding.EncodeToString(body)
+	}
+	for _, m := range found {
+		resp.Metrics = append(resp.Metrics, m.WithQuantiles())
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		h.logger.Error("json marhsaling error")
+		h.failInternal(w, r, "json marhsaling error")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+	h.logger.Info("MetricsQueryHandler exited")
+}
+
+// parseFilter reads filter from query parameters
+func parseFilter(r *http.Request) (storage.MetricsFilter, error) {
+	query := r.URL.Query()
+	filter := storage.MetricsFilter{
+		Type:   query.Get("type"),
+		Prefix: query.Get("prefix"),
+		Glob:   query.Get("glob"),
+		Regex:  query.Get("regex"),
+		Sort:   query.Get("sort"),
+		Limit:  defaultQueryLimit,
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxQueryLimit {
+			return filter, &metrics.FieldError{Field: "limit", Message: "wrong limit " + raw}
+		}
+		filter.Limit = limit
+	}
+	if raw := query.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return filter, &metrics.FieldError{Field: "offset", Message: "wrong offset " + raw}
+		}
+		filter.Offset = offset
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		body, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil {
+			return filter, &metrics.FieldError{Field: "cursor", Message: "wrong cursor"}
+		}
+		var cursor storage.Cursor
+		if err := json.Unmarshal(body, &cursor); err != nil {
+			return filter, &metrics.FieldError{Field: "cursor", Message: "wrong cursor"}
+		}
+		filter.After = &cursor
+	}
+	return filter, nil
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/ingest"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// RemoteWriteHandler accepts Prometheus remote_write requests: snappy-compressed protobuf WriteRequest.
+// Series ending with _total are counters, the rest are gauges.
+// Counter delta is taken from the last total pushed for the series, first push of a new series counts in full
+func (h *Handler) RemoteWriteHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered RemoteWriteHandler")
+
+	if r.Method != http.MethodPost {
+		h.failMethod(w, r, "Only POST requests are allowed for write!")
+
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.logger.Errorf("Can not read request body %s", err)
+		h.failInternal(w, r, "Can not read request body")
+
+		return
+	}
+
+	series, err := ingest.DecodeRemoteWrite(body)
+	if err != nil {
+		h.logger.Errorf("remote write decoding error %s", err)
+		h.fail(w, r, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "wrong requests"})
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	// delta depends on previous total, so concurrent pushes of one series must not interleave
+	h.remoteWriteMu.Lock()
+	defer h.remoteWriteMu.Unlock()
+
+	batch := make([]metrics.Metrics, 0, len(series))
+	totals := make([]metrics.Metrics, 0, len(series))
+	for _, s := range series {
+		if !s.Counter() {
+			batch = append(batch, s.Metric(nil))
+			continue
+		}
+
+		// counters come as totals, storage adds deltas
+		total := s.Total()
+		point := metrics.Metrics{ID: s.Name, MType: "counter", Labels: s.Labels, Delta: &total}
+		var prevTotal *int64
+		if last, ok := h.remoteTotals.Last(point); ok {
+			prevTotal = last.Delta
+		} else if stored, ok := h.stor.Get(ctx, point); ok && stored.MType == "counter" {
+			// total of stored series is unknown after restart, so its first push is a baseline
+			prevTotal = &total
+		}
+		batch = append(batch, s.Metric(prevTotal))
+		totals = append(totals, point)
+	}
+
+	if len(batch) > 0 {
+		if err := h.stor.SetAll(ctx, batch); err != nil {
+			h.logger.Errorf("Cann't store metrics %s", err)
+			h.failStorage(w, r, err)
+
+			return
+		}
+	}
+	h.remoteTotals.Update(totals)
+
+	h.logger.Infof("Stored %d remote write series", len(batch))
+	w.WriteHeader(http.StatusNoContent)
+	h.logger.Info("RemoteWriteHandler exited")
+}
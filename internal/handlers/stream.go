@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+)
+
+// streamKeepAlive is interval of comments keeping idle stream open through proxies
+const streamKeepAlive = 15 * time.Second
+
+// StreamHandler pushes current values and then changes of metrics as Server-Sent Events.
+// Metrics are filtered by ?type=&prefix=&glob=&regex=
+func (h *Handler) StreamHandler(w http.ResponseWriter, r *http.Request) {
+
+	h.logger.Info("Entered StreamHandler")
+
+	if r.Method != http.MethodGet {
+		h.failMethod(w, r, "Only GET requests are allowed for stream!")
+
+		return
+	}
+
+	notifier, ok := h.stor.(storage.MetricsNotifier)
+	if !ok {
+		h.logger.Error("storage doesn't notify about changes")
+		h.fail(w, r, http.StatusNotImplemented, APIError{Code: CodeNotImplemented, Message: "stream is not supported"})
+
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.MetricsFilter{
+		Type:   query.Get("type"),
+		Prefix: query.Get("prefix"),
+		Glob:   query.Get("glob"),
+		Regex:  query.Get("regex"),
+	}
+	// subscribe before reading current values, so no change is missed between them
+	sub, err := notifier.Subscribe(filter)
+	if err != nil {
+		h.logger.Errorf("Cann't subscribe %s", err)
+		h.failInvalid(w, r, CodeInvalidParam, err)
+
+		return
+	}
+	defer sub.Close()
+
+	current, err := storage.FilterMetrics(h.stor.GetAll(r.Context()), filter)
+	if err != nil {
+		h.failInvalid(w, r, CodeInvalidParam, err)
+
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if err := h.writeEvents(w, current); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		h.logger.Errorf("Stream can't be flushed %s", err)
+
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			h.logger.Info("StreamHandler exited")
+			return
+		case <-sub.Done():
+			h.logger.Info("StreamHandler stopped")
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-sub.Ready():
+			if err := h.writeEvents(w, sub.Take()); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvents writes one "metric" event per metric
+func (h *Handler) writeEvents(w http.ResponseWriter, changed []metrics.Metrics) error {
+	for _, m := range changed {
+		data, err := json.Marshal(m.WithQuantiles())
+		if err != nil {
+			h.logger.Error("json marhsaling error")
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: metric\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
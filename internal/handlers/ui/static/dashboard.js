@@ -0,0 +1,125 @@
+// Dashboard refreshes shown values through POST /value/ and keeps sparkline of metric page going.
+(function () {
+	'use strict';
+
+	var refreshInterval = 5000;
+	var maxSamples = 500;
+
+	function number(v) {
+		return v === undefined || v === null ? '0' : String(v);
+	}
+
+	// format matches formatValue of handlers package
+	function format(m) {
+		switch (m.type) {
+		case 'gauge':
+			return number(m.value);
+		case 'counter':
+			return number(m.delta);
+		case 'histogram':
+			return 'count ' + number(m.count) + ', sum ' + number(m.sum);
+		case 'summary':
+			var res = 'count ' + number(m.count) + ', sum ' + number(m.sum);
+			Object.keys(m.quantiles || {}).sort(function (a, b) { return a - b; }).forEach(function (q) {
+				res += ', q' + q + ' ' + number(m.quantiles[q]);
+			});
+			return res;
+		}
+		return '';
+	}
+
+	// sample matches value kept in history
+	function sample(m) {
+		switch (m.type) {
+		case 'gauge':
+			return m.value || 0;
+		case 'counter':
+			return m.delta || 0;
+		}
+		return m.count || 0;
+	}
+
+	function fetchValue(request) {
+		return fetch('/value/', {
+			method: 'POST',
+			headers: {'Content-Type': 'application/json'},
+			body: request
+		}).then(function (resp) {
+			return resp.ok ? resp.json() : null;
+		}).catch(function () {
+			return null;
+		});
+	}
+
+	function visible(el) {
+		return el.style.display !== 'none';
+	}
+
+	var search = document.getElementById('search');
+	var rows = Array.prototype.slice.call(document.querySelectorAll('#metrics tr[data-metric]'));
+
+	function filterRows() {
+		var q = search.value.trim().toLowerCase();
+		rows.forEach(function (row) {
+			row.style.display = row.dataset.search.indexOf(q) === -1 ? 'none' : '';
+		});
+	}
+
+	if (search && rows.length > 0) {
+		search.addEventListener('input', filterRows);
+		search.form.addEventListener('submit', function (e) {
+			e.preventDefault();
+			filterRows();
+		});
+		setInterval(function () {
+			rows.filter(visible).forEach(function (row) {
+				fetchValue(row.dataset.metric).then(function (m) {
+					if (m) {
+						row.querySelector('.value').textContent = format(m);
+					}
+				});
+			});
+		}, refreshInterval);
+	}
+
+	var metric = document.getElementById('metric');
+	if (!metric) {
+		return;
+	}
+
+	var samples = JSON.parse(metric.dataset.samples || '[]');
+	var svg = metric.querySelector('.sparkline');
+	var line = svg.querySelector('polyline');
+	var box = svg.viewBox.baseVal;
+
+	// draw matches sparkline of handlers package
+	function draw() {
+		if (samples.length < 2) {
+			line.setAttribute('points', '');
+			return;
+		}
+		var lo = Math.min.apply(null, samples);
+		var hi = Math.max.apply(null, samples);
+		var span = hi - lo || 1;
+		var points = samples.map(function (v, i) {
+			var x = i * box.width / (samples.length - 1);
+			var y = box.height - (v - lo) / span * box.height;
+			return x.toFixed(1) + ',' + y.toFixed(1);
+		});
+		line.setAttribute('points', points.join(' '));
+	}
+
+	setInterval(function () {
+		fetchValue(metric.dataset.metric).then(function (m) {
+			if (!m) {
+				return;
+			}
+			metric.querySelector('.current').textContent = format(m);
+			samples.push(sample(m));
+			if (samples.length > maxSamples) {
+				samples.shift();
+			}
+			draw();
+		});
+	}, refreshInterval);
+})();
@@ -0,0 +1,175 @@
+package ingest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// LineError is a parse error of one line of text protocol, lines are numbered from 1
+type LineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ParseInflux parses Influx line protocol: measurement[,tag=value...] field=value[,field=value...] [timestamp].
+// Every field becomes metric measurement_field labeled with tags: integer (i) and unsigned (u) fields are counters,
+// float and boolean fields are gauges, string fields are skipped. Timestamps are checked but not stored
+func ParseInflux(r io.Reader) ([]metrics.Metrics, []LineError) {
+	res := make([]metrics.Metrics, 0)
+	errs := make([]LineError, 0)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	num := 0
+	for scanner.Scan() {
+		num++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lineMetrics, err := parseInfluxLine(line)
+		if err != nil {
+			errs = append(errs, LineError{Line: num, Error: err.Error()})
+			continue
+		}
+		res = append(res, lineMetrics...)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, LineError{Line: num + 1, Error: err.Error()})
+	}
+	return res, errs
+}
+
+func parseInfluxLine(line string) ([]metrics.Metrics, error) {
+	sections := splitUnescaped(line, ' ')
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, errors.New("line must have measurement, fields and optional timestamp")
+	}
+	if len(sections) == 3 {
+		if _, err := strconv.ParseInt(sections[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("wrong timestamp %q", sections[2])
+		}
+	}
+
+	key := splitUnescaped(sections[0], ',')
+	measurement := unescape(key[0])
+	if measurement == "" {
+		return nil, errors.New("empty measurement")
+	}
+	var labels map[string]string
+	for _, tag := range key[1:] {
+		pair := splitUnescaped(tag, '=')
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("wrong tag %q", tag)
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[unescape(pair[0])] = unescape(pair[1])
+	}
+
+	res := make([]metrics.Metrics, 0)
+	for _, field := range splitUnescaped(sections[1], ',') {
+		pair := splitUnescaped(field, '=')
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("wrong field %q", field)
+		}
+		metric, ok, err := influxField(measurement+"_"+unescape(pair[0]), pair[1])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		metric.Labels = labels
+		res = append(res, metric)
+	}
+	return res, nil
+}
+
+// influxField converts field value to metric, ok is false for string fields
+func influxField(id, raw string) (metrics.Metrics, bool, error) {
+	metric := metrics.Metrics{ID: id}
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return metric, false, fmt.Errorf("unterminated string field %s", id)
+		}
+		return metric, false, nil
+	case strings.HasSuffix(raw, "i"), strings.HasSuffix(raw, "u"):
+		var delta int64
+		var err error
+		if strings.HasSuffix(raw, "i") {
+			delta, err = strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		} else {
+			var u uint64
+			u, err = strconv.ParseUint(raw[:len(raw)-1], 10, 63)
+			delta = int64(u)
+		}
+		if err != nil {
+			return metric, false, fmt.Errorf("wrong integer field %s=%s", id, raw)
+		}
+		metric.MType = "counter"
+		metric.Delta = &delta
+		return metric, true, nil
+	}
+
+	var value float64
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		value = 1
+	case "f", "F", "false", "False", "FALSE":
+		value = 0
+	default:
+		var err error
+		value, err = strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return metric, false, fmt.Errorf("wrong field %s=%s", id, raw)
+		}
+	}
+	metric.MType = "gauge"
+	metric.Value = &value
+	return metric, true, nil
+}
+
+// splitUnescaped splits s by sep that is not escaped with backslash and not inside double quotes.
+// Parts keep their escapes
+func splitUnescaped(s string, sep byte) []string {
+	parts := make([]string, 0, 2)
+	start := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescape removes backslashes before escaped commas, spaces, equal signs, quotes and backslashes
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(` ,="\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInflux(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	delta := func(d int64) *int64 { return &d }
+
+	tests := []struct {
+		name       string
+		body       string
+		want       []metrics.Metrics
+		wantErrors []int
+	}{
+		{
+			name: "gauge and counter fields",
+			body: "cpu,host=a,region=eu usage=0.5,ticks=10i 1700000000000000000",
+			want: []metrics.Metrics{
+				{ID: "cpu_usage", MType: "gauge", Value: value(0.5), Labels: map[string]string{"host": "a", "region": "eu"}},
+				{ID: "cpu_ticks", MType: "counter", Delta: delta(10), Labels: map[string]string{"host": "a", "region": "eu"}},
+			},
+		},
+		{
+			name: "escaping",
+			body: `disk\ io,path=C:\\data,name=a\,b\ c\=d used\ pct=1e2,label="x, y=\"z\"",ok=true`,
+			want: []metrics.Metrics{
+				{ID: "disk io_used pct", MType: "gauge", Value: value(100), Labels: map[string]string{"path": `C:\data`, "name": "a,b c=d"}},
+				{ID: "disk io_ok", MType: "gauge", Value: value(1), Labels: map[string]string{"path": `C:\data`, "name": "a,b c=d"}},
+			},
+		},
+		{
+			name: "comments, blank lines and unsigned",
+			body: "# comment\n\nmem free=5u\n",
+			want: []metrics.Metrics{
+				{ID: "mem_free", MType: "counter", Delta: delta(5)},
+			},
+		},
+		{
+			name: "per line errors",
+			body: "good v=1\nno_fields\nbad v=abc\nbad_ts v=1 abc\nbad,tag v=1\nunterminated s=\"abc\ngood w=2",
+			want: []metrics.Metrics{
+				{ID: "good_v", MType: "gauge", Value: value(1)},
+				{ID: "good_w", MType: "gauge", Value: value(2)},
+			},
+			wantErrors: []int{2, 3, 4, 5, 6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := ParseInflux(strings.NewReader(tt.body))
+			assert.Equal(t, tt.want, got)
+			lines := make([]int, 0)
+			for _, e := range errs {
+				lines = append(lines, e.Line)
+			}
+			if tt.wantErrors == nil {
+				tt.wantErrors = []int{}
+			}
+			assert.Equal(t, tt.wantErrors, lines)
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package ingest
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// PreviousFunc returns stored metric, it is used to turn cumulative values into increments
+type PreviousFunc func(metric metrics.Metrics) (metrics.Metrics, bool)
+
+// otlpConverter keeps values seen in one request, so several points of one series are not counted twice.
+// seen holds resulting values of up-down counters and points holds cumulative points as sent
+type otlpConverter struct {
+	previous PreviousFunc
+	totals   *Totals
+	seen     map[string]metrics.Metrics
+	points   map[string]metrics.Metrics
+	res      []metrics.Metrics
+}
+
+// ConvertOTLP converts gauges, sums and explicit bucket histograms of OTLP request to metrics.
+// Resource and data point attributes become labels, data point attributes win on conflict.
+// Monotonic sums are counters and histograms are histograms: delta temporality is stored as is,
+// cumulative one is stored as increment from previous point in totals, lower value means reset.
+// Series that is stored but has no point in totals (e.g. after restart) takes its first point as a baseline.
+// Non-monotonic sums are gauges, delta ones are added to previous value.
+// Exponential histograms, summaries and data points with NaN or infinite value are skipped.
+// Returned points are the last cumulative points of request, they go to totals once batch is stored
+func ConvertOTLP(req *colmetricspb.ExportMetricsServiceRequest, previous PreviousFunc, totals *Totals) (batch, points []metrics.Metrics) {
+	c := &otlpConverter{
+		previous: previous,
+		totals:   totals,
+		seen:     make(map[string]metrics.Metrics),
+		points:   make(map[string]metrics.Metrics),
+		res:      make([]metrics.Metrics, 0),
+	}
+	for _, rm := range req.GetResourceMetrics() {
+		resourceLabels := attributes(nil, rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				c.convert(m, resourceLabels)
+			}
+		}
+	}
+
+	points = make([]metrics.Metrics, 0, len(c.points))
+	for _, p := range c.points {
+		points = append(points, p)
+	}
+	return c.res, points
+}
+
+func (c *otlpConverter) convert(m *metricspb.Metric, resourceLabels map[string]string) {
+	switch {
+	case m.GetGauge() != nil:
+		for _, dp := range m.GetGauge().GetDataPoints() {
+			value := numberValue(dp)
+			if !finite(value) {
+				continue
+			}
+			c.res = append(c.res, metrics.Metrics{
+				ID:     m.GetName(),
+				MType:  "gauge",
+				Value:  &value,
+				Labels: attributes(resourceLabels, dp.GetAttributes()),
+			})
+		}
+	case m.GetSum() != nil:
+		sum := m.GetSum()
+		cumulative := sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range sum.GetDataPoints() {
+			value := numberValue(dp)
+			if !finite(value) {
+				continue
+			}
+			metric := metrics.Metrics{ID: m.GetName(), Labels: attributes(resourceLabels, dp.GetAttributes())}
+			if sum.GetIsMonotonic() {
+				metric.MType = "counter"
+				c.counter(metric, int64(math.Round(value)), cumulative)
+			} else {
+				metric.MType = "gauge"
+				c.upDownCounter(metric, value, cumulative)
+			}
+		}
+	case m.GetHistogram() != nil:
+		hist := m.GetHistogram()
+		cumulative := hist.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range hist.GetDataPoints() {
+			metric, ok := histogram(m.GetName(), dp)
+			if !ok {
+				continue
+			}
+			metric.Labels = attributes(resourceLabels, dp.GetAttributes())
+			c.histogram(metric, cumulative)
+		}
+	}
+}
+
+// last returns value of series: seen in request or stored
+func (c *otlpConverter) last(metric metrics.Metrics) (metrics.Metrics, bool) {
+	if prev, ok := c.seen[metric.Key()]; ok {
+		return prev, true
+	}
+	return c.stored(metric)
+}
+
+// lastPoint returns previous cumulative point of series: seen in request or kept in totals.
+// Stored series without known point takes cur as a baseline, so it is not counted again
+func (c *otlpConverter) lastPoint(cur metrics.Metrics) (metrics.Metrics, bool) {
+	if prev, ok := c.points[cur.Key()]; ok {
+		return prev, true
+	}
+	if c.totals != nil {
+		if prev, ok := c.totals.Last(cur); ok {
+			return prev, true
+		}
+	}
+	if _, ok := c.stored(cur); ok {
+		return cur, true
+	}
+	return metrics.Metrics{}, false
+}
+
+func (c *otlpConverter) stored(metric metrics.Metrics) (metrics.Metrics, bool) {
+	if c.previous == nil {
+		return metrics.Metrics{}, false
+	}
+	prev, ok := c.previous(metric)
+	if !ok || prev.MType != metric.MType {
+		return metrics.Metrics{}, false
+	}
+	return prev, true
+}
+
+func (c *otlpConverter) counter(metric metrics.Metrics, value int64, cumulative bool) {
+	delta := value
+	if cumulative {
+		total := value
+		point := metrics.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels, Delta: &total}
+		if prev, ok := c.lastPoint(point); ok && prev.Delta != nil && total >= *prev.Delta {
+			delta = total - *prev.Delta
+		}
+		c.points[metric.Key()] = point
+	}
+	metric.Delta = &delta
+	c.res = append(c.res, metric)
+}
+
+func (c *otlpConverter) upDownCounter(metric metrics.Metrics, value float64, cumulative bool) {
+	if !cumulative {
+		if prev, ok := c.last(metric); ok && prev.Value != nil {
+			value += *prev.Value
+		}
+	}
+	metric.Value = &value
+	c.seen[metric.Key()] = metric
+	c.res = append(c.res, metric)
+}
+
+func (c *otlpConverter) histogram(metric metrics.Metrics, cumulative bool) {
+	update := metric
+	if cumulative {
+		if prev, ok := c.lastPoint(metric); ok {
+			if diff, ok := subtractHistograms(metric, prev); ok {
+				update = diff
+			}
+		}
+		c.points[metric.Key()] = metric
+	}
+	c.res = append(c.res, update)
+}
+
+// subtractHistograms returns increment from prev to cur, ok is false on reset or other buckets
+func subtractHistograms(cur, prev metrics.Metrics) (metrics.Metrics, bool) {
+	if len(cur.Buckets) != len(prev.Buckets) || prev.Count == nil || *cur.Count < *prev.Count {
+		return metrics.Metrics{}, false
+	}
+	res := cur
+	res.Buckets = make([]metrics.Bucket, len(cur.Buckets))
+	for i, b := range cur.Buckets {
+		if b.Le != prev.Buckets[i].Le || b.Count < prev.Buckets[i].Count {
+			return metrics.Metrics{}, false
+		}
+		res.Buckets[i] = metrics.Bucket{Le: b.Le, Count: b.Count - prev.Buckets[i].Count}
+	}
+	count := *cur.Count - *prev.Count
+	sum := *cur.Sum
+	if prev.Sum != nil {
+		sum -= *prev.Sum
+	}
+	res.Count = &count
+	res.Sum = &sum
+	return res, true
+}
+
+// histogram converts OTLP bucket counts to cumulative buckets, +Inf bucket is kept in Count
+func histogram(name string, dp *metricspb.HistogramDataPoint) (metrics.Metrics, bool) {
+	bounds := dp.GetExplicitBounds()
+	counts := dp.GetBucketCounts()
+	if len(counts) != 0 && len(counts) != len(bounds)+1 || !finite(dp.GetSum()) {
+		return metrics.Metrics{}, false
+	}
+
+	buckets := make([]metrics.Bucket, len(bounds))
+	var cum int64
+	for i, le := range bounds {
+		if len(counts) > 0 {
+			cum += int64(counts[i])
+		}
+		buckets[i] = metrics.Bucket{Le: le, Count: cum}
+	}
+	count := int64(dp.GetCount())
+	sum := dp.GetSum()
+	return metrics.Metrics{
+		ID:      name,
+		MType:   "histogram",
+		Buckets: buckets,
+		Count:   &count,
+		Sum:     &sum,
+	}, true
+}
+
+// finite reports whether v can be stored, NaN and infinity can't be encoded to JSON
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func numberValue(dp *metricspb.NumberDataPoint) float64 {
+	switch v := dp.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		return v.AsDouble
+	case *metricspb.NumberDataPoint_AsInt:
+		return float64(v.AsInt)
+	}
+	return 0
+}
+
+// attributes returns base labels with attributes added, base is not changed
+func attributes(base map[string]string, attrs []*commonpb.KeyValue) map[string]string {
+	if len(base) == 0 && len(attrs) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(base)+len(attrs))
+	for k, v := range base {
+		labels[k] = v
+	}
+	for _, kv := range attrs {
+		labels[kv.GetKey()] = anyValue(kv.GetValue())
+	}
+	return labels
+}
+
+func anyValue(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,153 @@
+package ingest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func otlpRequest(ms ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "api"}}},
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: ms}},
+		}},
+	}
+}
+
+func sumMetric(name string, temporality metricspb.AggregationTemporality, monotonic bool, values ...int64) *metricspb.Metric {
+	points := make([]*metricspb.NumberDataPoint, 0, len(values))
+	for _, v := range values {
+		points = append(points, &metricspb.NumberDataPoint{Value: &metricspb.NumberDataPoint_AsInt{AsInt: v}})
+	}
+	return &metricspb.Metric{
+		Name: name,
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: temporality,
+			IsMonotonic:            monotonic,
+			DataPoints:             points,
+		}},
+	}
+}
+
+func TestConvertOTLP(t *testing.T) {
+	delta := func(d int64) *int64 { return &d }
+	value := func(v float64) *float64 { return &v }
+	labels := map[string]string{"service.name": "api"}
+
+	stored := map[string]metrics.Metrics{
+		"cumulative": {ID: "cumulative", MType: "counter", Delta: delta(10)},
+		"updown":     {ID: "updown", MType: "gauge", Value: value(5)},
+		"latency": {ID: "latency", MType: "histogram", Count: delta(2), Sum: value(1),
+			Buckets: []metrics.Bucket{{Le: 0.5, Count: 1}, {Le: 1, Count: 2}}},
+	}
+	previous := func(m metrics.Metrics) (metrics.Metrics, bool) {
+		prev, ok := stored[m.ID]
+		return prev, ok
+	}
+	// points sent before are the same as stored values until the first reset
+	totals := NewTotals()
+	for _, id := range []string{"cumulative", "latency"} {
+		point := stored[id]
+		point.Labels = labels
+		totals.Update([]metrics.Metrics{point})
+	}
+
+	req := otlpRequest(
+		&metricspb.Metric{
+			Name: "temperature",
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{
+				Value:      &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5},
+				Attributes: []*commonpb.KeyValue{{Key: "room", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 3}}}},
+			}}}},
+		},
+		sumMetric("delta", metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, true, 4),
+		// second point after the first one, third one is a reset
+		sumMetric("cumulative", metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, true, 15, 18, 2),
+		sumMetric("updown", metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, false, -2),
+		&metricspb.Metric{
+			Name: "latency",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				DataPoints: []*metricspb.HistogramDataPoint{{
+					Count:          5,
+					Sum:            value(4),
+					ExplicitBounds: []float64{0.5, 1},
+					BucketCounts:   []uint64{2, 2, 1},
+				}},
+			}},
+		},
+	)
+
+	got, points := ConvertOTLP(req, previous, totals)
+	require.Len(t, got, 7)
+	assert.Equal(t, metrics.Metrics{ID: "temperature", MType: "gauge", Value: value(21.5),
+		Labels: map[string]string{"service.name": "api", "room": "3"}}, got[0])
+	assert.Equal(t, metrics.Metrics{ID: "delta", MType: "counter", Delta: delta(4), Labels: labels}, got[1])
+	assert.Equal(t, int64(5), *got[2].Delta)
+	assert.Equal(t, int64(3), *got[3].Delta)
+	assert.Equal(t, int64(2), *got[4].Delta)
+	assert.Equal(t, 3.0, *got[5].Value)
+
+	hist := got[6]
+	require.NoError(t, hist.Validate())
+	assert.Equal(t, []metrics.Bucket{{Le: 0.5, Count: 1}, {Le: 1, Count: 2}}, hist.Buckets)
+	assert.Equal(t, int64(3), *hist.Count)
+	assert.Equal(t, 3.0, *hist.Sum)
+
+	// after reset increment is taken from the last point sent, not from the stored sum
+	require.Len(t, points, 2)
+	totals.Update(points)
+	stored["cumulative"] = metrics.Metrics{ID: "cumulative", MType: "counter", Delta: delta(20)}
+	got, _ = ConvertOTLP(otlpRequest(
+		sumMetric("cumulative", metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, true, 5),
+	), previous, totals)
+	require.Len(t, got, 1)
+	assert.Equal(t, int64(3), *got[0].Delta)
+
+	// stored series without known point starts from a baseline
+	got, _ = ConvertOTLP(otlpRequest(
+		sumMetric("cumulative", metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, true, 7, 9),
+	), previous, NewTotals())
+	require.Len(t, got, 2)
+	assert.Equal(t, int64(0), *got[0].Delta)
+	assert.Equal(t, int64(2), *got[1].Delta)
+
+	// values that can't be stored are skipped
+	nan := math.NaN()
+	req = otlpRequest(
+		&metricspb.Metric{
+			Name: "temperature",
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{
+				Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: nan},
+			}}}},
+		},
+		&metricspb.Metric{
+			Name: "requests",
+			Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+				DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: math.Inf(1)}}},
+			}},
+		},
+		&metricspb.Metric{
+			Name: "latency",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				DataPoints:             []*metricspb.HistogramDataPoint{{Count: 1, Sum: value(math.Inf(-1))}},
+			}},
+		},
+	)
+	got, points = ConvertOTLP(req, previous, totals)
+	assert.Empty(t, got)
+	assert.Empty(t, points)
+}
@@ -0,0 +1,216 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Series is a Prometheus series with its latest sample.
+// Counter value is cumulative total, not a delta
+type Series struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64
+}
+
+// Counter reports whether series is a Prometheus counter by naming convention
+func (s Series) Counter() bool {
+	return strings.HasSuffix(s.Name, "_total")
+}
+
+// Total returns cumulative value of counter series
+func (s Series) Total() int64 {
+	return int64(math.Round(s.Value))
+}
+
+// Metric converts series to gauge or counter, counter gets delta from previous total sent by the sender
+func (s Series) Metric(prevTotal *int64) metrics.Metrics {
+	metric := metrics.Metrics{
+		ID:     s.Name,
+		Labels: s.Labels,
+	}
+	if !s.Counter() {
+		value := s.Value
+		metric.MType = "gauge"
+		metric.Value = &value
+		return metric
+	}
+
+	total := s.Total()
+	delta := total
+	// counter reset starts from zero again
+	if prevTotal != nil && total >= *prevTotal {
+		delta = total - *prevTotal
+	}
+	metric.MType = "counter"
+	metric.Delta = &delta
+	return metric
+}
+
+// DecodeRemoteWrite decodes snappy-compressed protobuf WriteRequest.
+// Only the latest sample of every series is kept, also when series is repeated in request.
+// Stale markers (NaN) and infinite samples are skipped
+func DecodeRemoteWrite(compressed []byte) ([]Series, error) {
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("snappy decoding error: %w", err)
+	}
+
+	series := make([]Series, 0)
+	index := make(map[string]int)
+	err = walkMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		// WriteRequest.timeseries = 1
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		s, ok, err := decodeTimeSeries(field)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		key := metrics.Metrics{ID: s.Name, Labels: s.Labels}.Key()
+		if i, dup := index[key]; dup {
+			if s.Timestamp >= series[i].Timestamp {
+				series[i] = s
+			}
+			return nil
+		}
+		index[key] = len(series)
+		series = append(series, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+func decodeTimeSeries(data []byte) (Series, bool, error) {
+	s := Series{Labels: make(map[string]string)}
+	found := false
+	err := walkMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1: // labels
+			name, value, err := decodeLabel(field)
+			if err != nil {
+				return err
+			}
+			if name == "__name__" {
+				s.Name = value
+			} else {
+				s.Labels[name] = value
+			}
+		case 2: // samples
+			value, ts, err := decodeSample(field)
+			if err != nil {
+				return err
+			}
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil
+			}
+			if !found || ts >= s.Timestamp {
+				s.Value, s.Timestamp = value, ts
+				found = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Series{}, false, err
+	}
+	if s.Name == "" {
+		return Series{}, false, errors.New("series without __name__ label")
+	}
+	if len(s.Labels) == 0 {
+		s.Labels = nil
+	}
+	return s, found, nil
+}
+
+func decodeLabel(data []byte) (name, value string, err error) {
+	err = walkMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			name = string(field)
+		case 2:
+			value = string(field)
+		}
+		return nil
+	})
+	return name, value, err
+}
+
+func decodeSample(data []byte) (value float64, ts int64, err error) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return 0, 0, protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				return 0, 0, protowire.ParseError(n)
+			}
+			value = math.Float64frombits(v)
+			data = data[n:]
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return 0, 0, protowire.ParseError(n)
+			}
+			ts = int64(v)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return 0, 0, protowire.ParseError(n)
+			}
+			data = data[n:]
+		}
+	}
+	return value, ts, nil
+}
+
+// walkMessage calls fn for every field of protobuf message, field holds payload of length-delimited fields
+func walkMessage(data []byte, fn func(num protowire.Number, typ protowire.Type, field []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var field []byte
+		if typ == protowire.BytesType {
+			field, n = protowire.ConsumeBytes(data)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package ingest
+
+import "github.com/Mr-Punder/go-alerting-service/internal/metrics"
+
+// Totals keeps the last cumulative point of every series as the sender reported it.
+// Increments are computed from these points and not from stored values:
+// stored counter keeps sum of increments across resets, so after a reset it stays above sender's total.
+// Totals is not safe for concurrent use
+type Totals struct {
+	last map[string]metrics.Metrics
+}
+
+func NewTotals() *Totals {
+	return &Totals{last: make(map[string]metrics.Metrics)}
+}
+
+// Last returns the last point of series of the same type as metric
+func (t *Totals) Last(metric metrics.Metrics) (metrics.Metrics, bool) {
+	prev, ok := t.last[metric.Key()]
+	if !ok || prev.MType != metric.MType {
+		return metrics.Metrics{}, false
+	}
+	return prev, true
+}
+
+// Update remembers points, it is called once increments computed from them are stored
+func (t *Totals) Update(points []metrics.Metrics) {
+	for _, p := range points {
+		t.last[p.Key()] = p
+	}
+}
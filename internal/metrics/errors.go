@@ -0,0 +1,17 @@
+package metrics
+
+import "fmt"
+
+// FieldError is validation error of one field, Field is its JSON name
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+func fieldErrorf(field, format string, args ...any) error {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+)
+
+// Bucket is a cumulative histogram bucket: number of observations less or equal to Le
+type Bucket struct {
+	Le    float64 `json:"le"`
+	Count int64   `json:"count"`
+}
+
+// ErrBucketsMismatch is returned by MergeHistograms for histograms with different buckets
+var ErrBucketsMismatch = errors.New("histogram buckets mismatch")
+
+// DefaultBuckets are upper bounds of histogram created from single observation
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Validate checks metric type and consistency of histogram and summary
+func (m Metrics) Validate() error {
+	if !ValidType(m.MType) {
+		return fieldErrorf("type", "wrong type %s", m.MType)
+	}
+	if m.MType == "gauge" && m.Value == nil {
+		return fieldErrorf("value", "gauge without value")
+	}
+	if m.MType == "counter" && m.Delta == nil {
+		return fieldErrorf("delta", "counter without delta")
+	}
+	if m.MType == "summary" {
+		if m.Value == nil && m.Sketch == nil {
+			return fieldErrorf("value", "summary without value or sketch")
+		}
+		if m.Sketch != nil {
+			return m.Sketch.validate()
+		}
+		return nil
+	}
+	if m.MType != "histogram" {
+		return nil
+	}
+
+	if m.Count == nil {
+		return fieldErrorf("count", "histogram without count")
+	}
+	for i, b := range m.Buckets {
+		if math.IsNaN(b.Le) {
+			return fieldErrorf("buckets", "histogram bucket bound is NaN")
+		}
+		if i > 0 && b.Le <= m.Buckets[i-1].Le {
+			return fieldErrorf("buckets", "histogram buckets must be sorted by le")
+		}
+		if i > 0 && b.Count < m.Buckets[i-1].Count {
+			return fieldErrorf("buckets", "histogram bucket counts must be cumulative")
+		}
+		if b.Count < 0 || b.Count > *m.Count {
+			return fieldErrorf("buckets", "histogram bucket count is out of range")
+		}
+	}
+	return nil
+}
+
+// NewHistogram returns histogram with one observation of value
+func NewHistogram(id string, bounds []float64, value float64) Metrics {
+	buckets := make([]Bucket, len(bounds))
+	for i, le := range bounds {
+		buckets[i].Le = le
+		if value <= le {
+			buckets[i].Count = 1
+		}
+	}
+	var count int64 = 1
+	return Metrics{
+		ID:      id,
+		MType:   "histogram",
+		Buckets: buckets,
+		Count:   &count,
+		Sum:     &value,
+	}
+}
+
+// Bounds returns upper bounds of histogram buckets
+func (m Metrics) Bounds() []float64 {
+	bounds := make([]float64, len(m.Buckets))
+	for i, b := range m.Buckets {
+		bounds[i] = b.Le
+	}
+	return bounds
+}
+
+// MergeHistograms adds bucket counts, count and sum of histograms with the same buckets
+func MergeHistograms(stored, update Metrics) (Metrics, error) {
+	if len(stored.Buckets) != len(update.Buckets) {
+		return Metrics{}, ErrBucketsMismatch
+	}
+
+	res := stored
+	res.Buckets = make([]Bucket, len(stored.Buckets))
+	for i, b := range stored.Buckets {
+		if b.Le != update.Buckets[i].Le {
+			return Metrics{}, ErrBucketsMismatch
+		}
+		res.Buckets[i] = Bucket{Le: b.Le, Count: b.Count + update.Buckets[i].Count}
+	}
+
+	var count int64
+	var sum float64
+	if stored.Count != nil {
+		count = *stored.Count
+	}
+	if stored.Sum != nil {
+		sum = *stored.Sum
+	}
+	if update.Count != nil {
+		count += *update.Count
+	}
+	if update.Sum != nil {
+		sum += *update.Sum
+	}
+	res.Count = &count
+	res.Sum = &sum
+	return res, nil
+}
@@ -4,14 +4,55 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Metric is a type of Go runtime parameter
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
+	ID        string             `json:"id"`
+	MType     string             `json:"type"`
+	Delta     *int64             `json:"delta,omitempty"`
+	Value     *float64           `json:"value,omitempty"`
+	Buckets   []Bucket           `json:"buckets,omitempty"`
+	Count     *int64             `json:"count,omitempty"`
+	Sum       *float64           `json:"sum,omitempty"`
+	Sketch    *Sketch            `json:"sketch,omitempty"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+	Labels    map[string]string  `json:"labels,omitempty"`
+}
+
+// ValidType reports whether metric type is known
+func ValidType(mtype string) bool {
+	return mtype == "gauge" || mtype == "counter" || mtype == "histogram" || mtype == "summary"
+}
+
+// Key returns identity of metric: ID for unlabeled metric or ID{k1="v1",k2="v2"} with sorted labels
+func (m Metrics) Key() string {
+	if len(m.Labels) == 0 {
+		return m.ID
+	}
+
+	names := make([]string, 0, len(m.Labels))
+	for name := range m.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(m.ID)
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(m.Labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
 }
 
 func (m Metrics) MarshalJSON() ([]byte, error) {
@@ -60,6 +101,31 @@ func (m Metrics) MarshalJSON() ([]byte, error) {
 			panic(err)
 		}
 		return data, nil
+	case "histogram", "summary":
+		var Count int64
+		var Sum float64
+		if m.Count != nil {
+			Count = *m.Count
+		}
+		if m.Sum != nil {
+			Sum = *m.Sum
+		}
+		aliasMetric := struct {
+			*MetricAlias
+
+			Count int64   `json:"count"`
+			Sum   float64 `json:"sum"`
+		}{
+			MetricAlias: (*MetricAlias)(&m),
+			Count:       Count,
+			Sum:         Sum,
+		}
+		data, err := json.Marshal(aliasMetric)
+		if err != nil {
+			log.Fatal(err)
+			panic(err)
+		}
+		return data, nil
 	default:
 		return []byte{}, errors.New("uncknown type")
 	}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// SketchCompression bounds size of summary sketch, it keeps about SketchCompression centroids
+const SketchCompression = 100
+
+// DefaultQuantiles are returned in Quantiles field of summary
+var DefaultQuantiles = []float64{0.5, 0.95, 0.99}
+
+// Centroid is a cluster of observations with mean value and number of observations
+type Centroid struct {
+	Mean   float64 `json:"m"`
+	Weight float64 `json:"w"`
+}
+
+// Sketch is a mergeable t-digest of observed values
+type Sketch struct {
+	Min       float64    `json:"min"`
+	Max       float64    `json:"max"`
+	Centroids []Centroid `json:"centroids"`
+}
+
+// Count returns number of observations in sketch
+func (s *Sketch) Count() float64 {
+	var count float64
+	for _, c := range s.Centroids {
+		count += c.Weight
+	}
+	return count
+}
+
+// Add adds one observation to sketch
+func (s *Sketch) Add(value float64) {
+	s.Merge(&Sketch{Min: value, Max: value, Centroids: []Centroid{{Mean: value, Weight: 1}}})
+}
+
+// Merge adds all observations of other sketch and compresses result
+func (s *Sketch) Merge(other *Sketch) {
+	if other == nil || len(other.Centroids) == 0 {
+		return
+	}
+	if len(s.Centroids) == 0 {
+		s.Min, s.Max = other.Min, other.Max
+	} else {
+		s.Min = math.Min(s.Min, other.Min)
+		s.Max = math.Max(s.Max, other.Max)
+	}
+
+	all := make([]Centroid, 0, len(s.Centroids)+len(other.Centroids))
+	all = append(all, s.Centroids...)
+	all = append(all, other.Centroids...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Mean < all[j].Mean })
+
+	total := 0.0
+	for _, c := range all {
+		total += c.Weight
+	}
+
+	// neighbours are merged while they fit into one unit of k1 scale,
+	// so centroids are small near the tails and large near the median
+	res := make([]Centroid, 0, SketchCompression)
+	res = append(res, all[0])
+	before := 0.0
+	for _, c := range all[1:] {
+		last := &res[len(res)-1]
+		if scale(before/total)+1 >= scale((before+last.Weight+c.Weight)/total) {
+			last.Weight += c.Weight
+			last.Mean += (c.Mean - last.Mean) * c.Weight / last.Weight
+			continue
+		}
+		before += last.Weight
+		res = append(res, c)
+	}
+	s.Centroids = res
+}
+
+// scale is k1 scale function of t-digest
+func scale(q float64) float64 {
+	return SketchCompression / (2 * math.Pi) * math.Asin(2*math.Min(q, 1)-1)
+}
+
+// Quantile returns estimated q-quantile of observations, NaN for empty sketch
+func (s *Sketch) Quantile(q float64) float64 {
+	if len(s.Centroids) == 0 {
+		return math.NaN()
+	}
+	if len(s.Centroids) == 1 {
+		return s.Centroids[0].Mean
+	}
+
+	// values are interpolated between centroid centers, min and max are the ends
+	target := q * s.Count()
+	first := s.Centroids[0]
+	if target < first.Weight/2 {
+		return s.Min + (first.Mean-s.Min)*target/(first.Weight/2)
+	}
+
+	cum := 0.0
+	for i := 0; i < len(s.Centroids)-1; i++ {
+		left, right := s.Centroids[i], s.Centroids[i+1]
+		leftCenter := cum + left.Weight/2
+		rightCenter := cum + left.Weight + right.Weight/2
+		if target <= rightCenter {
+			return left.Mean + (right.Mean-left.Mean)*(target-leftCenter)/(rightCenter-leftCenter)
+		}
+		cum += left.Weight
+	}
+
+	last := s.Centroids[len(s.Centroids)-1]
+	lastCenter := cum + last.Weight/2
+	tail := cum + last.Weight - lastCenter
+	return last.Mean + (s.Max-last.Mean)*math.Min(target-lastCenter, tail)/tail
+}
+
+func (s *Sketch) validate() error {
+	for _, c := range s.Centroids {
+		if math.IsNaN(c.Mean) || math.IsInf(c.Mean, 0) {
+			return fieldErrorf("sketch", "summary centroid mean is not finite")
+		}
+		if !(c.Weight > 0) {
+			return fieldErrorf("sketch", "summary centroid weight must be positive")
+		}
+	}
+	return nil
+}
+
+// MergeSummaries adds observations of update to stored summary.
+// Update carries one observation in Value or a sketch with its Sum
+func MergeSummaries(stored, update Metrics) Metrics {
+	sketch := &Sketch{}
+	var count int64
+	var sum float64
+	if stored.Sketch != nil {
+		sketch.Merge(stored.Sketch)
+	}
+	if stored.Count != nil {
+		count = *stored.Count
+	}
+	if stored.Sum != nil {
+		sum = *stored.Sum
+	}
+
+	if update.Value != nil {
+		sketch.Add(*update.Value)
+		count++
+		sum += *update.Value
+	}
+	if update.Sketch != nil {
+		sketch.Merge(update.Sketch)
+		count += int64(math.Round(update.Sketch.Count()))
+		if update.Sum != nil {
+			sum += *update.Sum
+		}
+	}
+
+	return Metrics{
+		ID:     update.ID,
+		MType:  "summary",
+		Sketch: sketch,
+		Count:  &count,
+		Sum:    &sum,
+		Labels: update.Labels,
+	}
+}
+
+// WithQuantiles fills Quantiles of summary with DefaultQuantiles, other metrics are returned as is
+func (m Metrics) WithQuantiles() Metrics {
+	if m.MType != "summary" || m.Sketch == nil || len(m.Sketch.Centroids) == 0 {
+		return m
+	}
+	m.Quantiles = make(map[string]float64, len(DefaultQuantiles))
+	for _, q := range DefaultQuantiles {
+		m.Quantiles[strconv.FormatFloat(q, 'g', -1, 64)] = m.Sketch.Quantile(q)
+	}
+	return m
+}
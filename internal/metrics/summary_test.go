@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSketch(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		parts int
+	}{
+		{name: "single sketch", n: 10000, parts: 1},
+		{name: "merged sketches", n: 10000, parts: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			values := rnd.Perm(tt.n)
+
+			sketch := &Sketch{}
+			for p := 0; p < tt.parts; p++ {
+				part := &Sketch{}
+				for _, v := range values[p*tt.n/tt.parts : (p+1)*tt.n/tt.parts] {
+					part.Add(float64(v))
+				}
+				sketch.Merge(part)
+			}
+
+			assert.LessOrEqual(t, len(sketch.Centroids), SketchCompression)
+			assert.InDelta(t, float64(tt.n), sketch.Count(), 1e-9)
+			assert.Equal(t, 0.0, sketch.Min)
+			assert.Equal(t, float64(tt.n-1), sketch.Max)
+			for _, q := range []float64{0.5, 0.95, 0.99} {
+				assert.InDelta(t, q*float64(tt.n), sketch.Quantile(q), 0.01*float64(tt.n), "quantile %v", q)
+			}
+		})
+	}
+}
+
+func TestMergeSummaries(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+
+	m := Metrics{ID: "s", MType: "summary", Value: value(1)}
+	require.NoError(t, m.Validate())
+	m = MergeSummaries(Metrics{}, m)
+	m = MergeSummaries(m, Metrics{ID: "s", MType: "summary", Value: value(3)})
+
+	other := &Sketch{}
+	other.Add(5)
+	m = MergeSummaries(m, Metrics{ID: "s", MType: "summary", Sketch: other, Sum: value(5)})
+
+	assert.Equal(t, int64(3), *m.Count)
+	assert.Equal(t, 9.0, *m.Sum)
+	assert.Nil(t, m.Value)
+	assert.Equal(t, map[string]float64{"0.5": 3, "0.95": 5, "0.99": 5}, m.WithQuantiles().Quantiles)
+
+	assert.Error(t, Metrics{ID: "s", MType: "summary"}.Validate())
+	assert.Error(t, Metrics{ID: "s", MType: "summary", Sketch: &Sketch{Centroids: []Centroid{{Mean: 1}}}}.Validate())
+}
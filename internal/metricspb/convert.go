@@ -0,0 +1,59 @@
+package metricspb
+
+import (
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// FromMetric converts metric to protobuf message
+func FromMetric(m metrics.Metrics) *Metric {
+	res := &Metric{
+		Id:        m.ID,
+		Type:      m.MType,
+		Delta:     m.Delta,
+		Value:     m.Value,
+		Count:     m.Count,
+		Sum:       m.Sum,
+		Quantiles: m.Quantiles,
+		Labels:    m.Labels,
+	}
+	for _, b := range m.Buckets {
+		res.Buckets = append(res.Buckets, &Bucket{Le: b.Le, Count: b.Count})
+	}
+	if m.Sketch != nil {
+		res.Sketch = &Sketch{Min: m.Sketch.Min, Max: m.Sketch.Max}
+		for _, c := range m.Sketch.Centroids {
+			res.Sketch.Centroids = append(res.Sketch.Centroids, &Centroid{Mean: c.Mean, Weight: c.Weight})
+		}
+	}
+	return res
+}
+
+// ToMetric converts protobuf message to metric
+func ToMetric(m *Metric) metrics.Metrics {
+	res := metrics.Metrics{
+		ID:        m.GetId(),
+		MType:     m.GetType(),
+		Delta:     m.Delta,
+		Value:     m.Value,
+		Count:     m.Count,
+		Sum:       m.Sum,
+		Quantiles: m.GetQuantiles(),
+		Labels:    m.GetLabels(),
+	}
+	for _, b := range m.GetBuckets() {
+		res.Buckets = append(res.Buckets, metrics.Bucket{Le: b.GetLe(), Count: b.GetCount()})
+	}
+	if m.GetSketch() != nil {
+		res.Sketch = &metrics.Sketch{Min: m.GetSketch().GetMin(), Max: m.GetSketch().GetMax()}
+		for _, c := range m.GetSketch().GetCentroids() {
+			res.Sketch.Centroids = append(res.Sketch.Centroids, metrics.Centroid{Mean: c.GetMean(), Weight: c.GetWeight()})
+		}
+	}
+	if len(res.Quantiles) == 0 {
+		res.Quantiles = nil
+	}
+	if len(res.Labels) == 0 {
+		res.Labels = nil
+	}
+	return res
+}
@@ -0,0 +1,4 @@
+// Package metricspb contains gRPC API of metrics server generated from metrics.proto
+package metricspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
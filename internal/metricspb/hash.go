@@ -0,0 +1,48 @@
+package metricspb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// HashKey is metadata key of HMAC-SHA256 signature, gRPC counterpart of HashSHA256 header
+const HashKey = "hashsha256"
+
+// Signer computes hex HMAC-SHA256 of deterministically marshaled messages in order
+type Signer struct {
+	h hash.Hash
+}
+
+func NewSigner(key string) *Signer {
+	return &Signer{h: hmac.New(sha256.New, []byte(key))}
+}
+
+// Add adds message to signature
+func (s *Signer) Add(msg proto.Message) error {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.h.Write(data)
+	return nil
+}
+
+// Sum returns hex signature of added messages
+func (s *Signer) Sum() string {
+	return hex.EncodeToString(s.h.Sum(nil))
+}
+
+// Sign returns hex signature of messages
+func Sign(key string, msgs ...proto.Message) (string, error) {
+	s := NewSigner(key)
+	for _, msg := range msgs {
+		if err := s.Add(msg); err != nil {
+			return "", err
+		}
+	}
+	return s.Sum(), nil
+}
@@ -58,15 +58,16 @@ func (c *GzipCompressor) CompressHandler(next http.Handler) http.Handler {
 			}
 		}
 
-		if supportGzip {
+		if supportGzip && !streaming(r) {
 
 			c.log.Info("Detected gzip support")
 
 			rw := gzipcomp.NewGzipResponseWriter(w)
 			next.ServeHTTP(rw, r)
 
+			status := rw.Status()
 			contentType := rw.Header().Get("Content-Type")
-			if contentType == "text/html" || contentType == "application/json" {
+			if compressible(contentType) && (status == 0 || status == http.StatusOK) {
 
 				cw := gzipcomp.NewGzipCompressWriter(w)
 				cw.Header().Set("Content-Encoding", "gzip")
@@ -74,6 +75,11 @@ func (c *GzipCompressor) CompressHandler(next http.Handler) http.Handler {
 
 				ow = cw
 				rw.WriteTo(cw)
+			} else {
+				if status != 0 {
+					w.WriteHeader(status)
+				}
+				rw.WriteTo(w)
 			}
 
 		} else {
@@ -83,3 +89,18 @@ func (c *GzipCompressor) CompressHandler(next http.Handler) http.Handler {
 
 	})
 }
+
+// compressible reports whether response of content type is worth compression
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(mediaType) {
+	case "text/html", "application/json", "text/plain":
+		return true
+	}
+	return false
+}
+
+// streaming reports whether client waits for event stream, which can't be buffered
+func streaming(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"io"
@@ -70,7 +71,7 @@ func TestGzipCompressor(t *testing.T) {
 	comp := NewGzipCompressor(Log)
 	hlog := NewHTTPLoger(Log)
 
-	ts := httptest.NewServer(hlog.HTTPLogHandler(comp.CompressHandler(handlers.NewMetricRouter(stor, Log))))
+	ts := httptest.NewServer(hlog.HTTPLogHandler(comp.CompressHandler(handlers.NewMetricRouter(stor, nil, Log))))
 	defer ts.Close()
 
 	requestBody := "{\"id\":\"g\",\"type\":\"gauge\",\"value\":5.2}"
@@ -130,4 +131,47 @@ func TestGzipCompressor(t *testing.T) {
 		// require.Equal(t, wantBody, string(strbody))
 
 	})
+	t.Run("receiving_gzip_prometheus", func(t *testing.T) {
+		headers := map[string]string{
+			"Accept-Encoding": "gzip",
+		}
+		resp := testRequest(t, ts, http.MethodGet, "/metrics", bytes.NewBuffer(nil), headers, Log)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+		zr, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		b, err := io.ReadAll(zr)
+		resp.Body.Close()
+		require.NoError(t, err)
+
+		require.Contains(t, string(b), "# TYPE gaugeMetric gauge\ngaugeMetric 1.5\n")
+		require.Contains(t, string(b), "# TYPE counterMetric counter\ncounterMetric 2\n")
+	})
+	t.Run("not_found_with_gzip", func(t *testing.T) {
+		headers := map[string]string{
+			"Accept-Encoding": "gzip",
+		}
+		resp := testRequest(t, ts, http.MethodGet, "/value/gauge/unknown", bytes.NewBuffer(nil), headers, Log)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, "unknown not found\n", string(b))
+	})
+	t.Run("event_stream_is_not_buffered", func(t *testing.T) {
+		headers := map[string]string{
+			"Accept":          "text/event-stream",
+			"Accept-Encoding": "gzip",
+		}
+		resp := testRequest(t, ts, http.MethodGet, "/stream?prefix=gauge", bytes.NewBuffer(nil), headers, Log)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Empty(t, resp.Header.Get("Content-Encoding"))
+
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "event: metric\n", line)
+	})
 }
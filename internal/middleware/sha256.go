@@ -77,7 +77,7 @@ func (hs *HashSum) HashSummHandler(next http.Handler) http.Handler {
 			hs.log.Error("Hash has not detected")
 		}
 
-		if hs.key != "" {
+		if hs.key != "" && !streaming(r) {
 			hashWriter := NewHashWriter(w)
 			next.ServeHTTP(hashWriter, r)
 			respBody := hashWriter.buffer.Bytes()
@@ -0,0 +1,117 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/alerting"
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+)
+
+// WebhookNotifier posts alert state changes to webhook urls
+type WebhookNotifier struct {
+	urls    []string
+	key     string
+	log     logger.Logger
+	client  *http.Client
+	queue   chan alerting.Alert
+	retries int
+	backoff time.Duration
+}
+
+func NewWebhookNotifier(urls []string, key string, log logger.Logger) *WebhookNotifier {
+	return &WebhookNotifier{
+		urls:    urls,
+		key:     key,
+		log:     log,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		queue:   make(chan alerting.Alert, 100),
+		retries: 3,
+		backoff: time.Second,
+	}
+}
+
+// Notify queues alert for sending and never blocks evaluation
+func (n *WebhookNotifier) Notify(alert alerting.Alert) {
+	select {
+	case n.queue <- alert:
+	default:
+		n.log.Errorf("Notification queue is full, alert %q dropped", alert.Rule)
+	}
+}
+
+// Run sends queued notifications until ctx is done
+func (n *WebhookNotifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-n.queue:
+			body, err := json.Marshal(alert)
+			if err != nil {
+				n.log.Errorf("Error encoding alert %s", err)
+				continue
+			}
+			for _, url := range n.urls {
+				if err := n.send(ctx, url, body); err != nil {
+					n.log.Errorf("Error sending alert %q to %s: %s", alert.Rule, url, err)
+				}
+			}
+		}
+	}
+}
+
+func (n *WebhookNotifier) send(ctx context.Context, url string, body []byte) error {
+	var err error
+	backoff := n.backoff
+	for i := 0; i <= n.retries; i++ {
+		if i > 0 {
+			n.log.Infof("Retrying notification to %s in %s", url, backoff)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		err = n.post(ctx, url, body)
+		if err == nil {
+			n.log.Infof("Notification sent to %s", url)
+			return nil
+		}
+	}
+	return err
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if n.key != "" {
+		h := hmac.New(sha256.New, []byte(n.key))
+		h.Write(body)
+		req.Header.Set("HashSHA256", hex.EncodeToString(h.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
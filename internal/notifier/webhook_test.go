@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/alerting"
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	key := "secret"
+	var mu sync.Mutex
+	requests := 0
+	received := make([]alerting.Alert, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		// first attempt fails to check retries
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		h := hmac.New(sha256.New, []byte(key))
+		h.Write(body)
+		assert.Equal(t, hex.EncodeToString(h.Sum(nil)), r.Header.Get("HashSHA256"))
+
+		var alert alerting.Alert
+		require.NoError(t, json.Unmarshal(body, &alert))
+		received = append(received, alert)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	notif := NewWebhookNotifier([]string{server.URL}, key, log)
+	notif.backoff = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notif.Run(ctx)
+
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", log)
+	require.NoError(t, err)
+	rule, err := alerting.ParseRule("HeapAlloc gauge > 100")
+	require.NoError(t, err)
+	engine := alerting.NewEngine([]alerting.Rule{rule}, stor, notif, log)
+
+	now := time.Now()
+	for i, v := range []float64{150, 200, 300, 50, 20} {
+		value := v
+		require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "HeapAlloc", MType: "gauge", Value: &value}))
+		engine.Evaluate(ctx, now.Add(time.Duration(i)*time.Minute))
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, alerting.StateFiring, received[0].State)
+	assert.Equal(t, alerting.StateResolved, received[1].State)
+	assert.Equal(t, 3, requests)
+}
@@ -4,26 +4,38 @@ import (
 	"flag"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	FlagRunAddr     string
-	LogLevel        string
-	LogOutputPath   string
-	LogErrorPath    string
-	StoreInterval   int64
-	FileStoragePath string
-	Restore         bool
-	DBstring        string
-	HashKey         string
+	FlagRunAddr       string
+	LogLevel          string
+	LogOutputPath     string
+	LogErrorPath      string
+	StoreInterval     int64
+	FileStoragePath   string
+	Restore           bool
+	DBstring          string
+	HashKey           string
+	RulesFile         string
+	AlertInterval     int64
+	Webhooks          []string
+	HistoryRetention  int64
+	IdempotencyWindow int64
+	StatsdAddress     string
+	StatsdFlush       int64
+	GraphiteAddress   string
+	GraphiteTemplate  string
+	GraphiteConns     int64
+	GRPCAddress       string
 }
 
 // New from environment and consol parameters
 func New() *Config {
 	var (
-		flagRunAddr, logLevel, logOutputPath, fileStoragePath, logErrortPath, dbString, rawKey string
-		storeInterval                                                                          int64
-		restore                                                                                bool
+		flagRunAddr, logLevel, logOutputPath, fileStoragePath, logErrortPath, dbString, rawKey, rulesFile, webhooks, statsdAddress, graphiteAddress, graphiteTemplate, grpcAddress string
+		storeInterval, alertInterval, historyRetention, idempotencyWindow, statsdFlush, graphiteConns                                                                              int64
+		restore                                                                                                                                                                    bool
 	)
 
 	flag.StringVar(&flagRunAddr, "a", "localhost:8080", "addres and port to run server")
@@ -36,6 +48,17 @@ func New() *Config {
 	flag.StringVar(&dbString, "d", "", "databese opening string")
 	// host=localhost user=metrics password=metrics_password dbname=metrics
 	flag.StringVar(&rawKey, "k", "", "Key for hash summ")
+	flag.StringVar(&rulesFile, "rules", "", "alerting rules file")
+	flag.Int64Var(&alertInterval, "ai", 10, "alerting rules evaluation interval")
+	flag.StringVar(&webhooks, "wh", "", "comma separated webhook urls for alert notifications")
+	flag.Int64Var(&historyRetention, "hr", 0, "metrics history retention in seconds, 0 disables history. With database every update also inserts a metric_history row")
+	flag.Int64Var(&idempotencyWindow, "iw", 600, "idempotency keys of batch updates are remembered for this many seconds, 0 disables them")
+	flag.StringVar(&statsdAddress, "statsd", "", "udp address of statsd listener, disabled if empty")
+	flag.Int64Var(&statsdFlush, "sf", 1, "statsd flush interval in seconds")
+	flag.StringVar(&graphiteAddress, "graphite", "", "tcp address of graphite listener, disabled if empty")
+	flag.StringVar(&graphiteTemplate, "gt", "", "semicolon separated graphite templates \"[filter] template\"")
+	flag.Int64Var(&graphiteConns, "gc", 100, "graphite connections limit")
+	flag.StringVar(&grpcAddress, "g", "", "address of grpc server, disabled if empty")
 
 	flag.Parse()
 
@@ -74,6 +97,82 @@ func New() *Config {
 
 		rawKey = envHashKey
 	}
+	if envRulesFile, ok := os.LookupEnv("ALERT_RULES"); ok {
 
-	return &Config{flagRunAddr, logLevel, logOutputPath, logErrortPath, storeInterval, fileStoragePath, restore, dbString, rawKey}
+		rulesFile = envRulesFile
+	}
+	if envAlertInterval, ok := os.LookupEnv("ALERT_INTERVAL"); ok {
+
+		alertInterval, _ = strconv.ParseInt(envAlertInterval, 10, 64)
+	}
+	if envWebhooks, ok := os.LookupEnv("ALERT_WEBHOOKS"); ok {
+
+		webhooks = envWebhooks
+	}
+
+	if envHistoryRetention, ok := os.LookupEnv("HISTORY_RETENTION"); ok {
+
+		historyRetention, _ = strconv.ParseInt(envHistoryRetention, 10, 64)
+	}
+	if envIdempotencyWindow, ok := os.LookupEnv("IDEMPOTENCY_WINDOW"); ok {
+
+		idempotencyWindow, _ = strconv.ParseInt(envIdempotencyWindow, 10, 64)
+	}
+
+	if envStatsdAddress, ok := os.LookupEnv("STATSD_ADDRESS"); ok {
+
+		statsdAddress = envStatsdAddress
+	}
+	if envStatsdFlush, ok := os.LookupEnv("STATSD_FLUSH_INTERVAL"); ok {
+
+		statsdFlush, _ = strconv.ParseInt(envStatsdFlush, 10, 64)
+	}
+
+	if envGraphiteAddress, ok := os.LookupEnv("GRAPHITE_ADDRESS"); ok {
+
+		graphiteAddress = envGraphiteAddress
+	}
+	if envGraphiteTemplate, ok := os.LookupEnv("GRAPHITE_TEMPLATES"); ok {
+
+		graphiteTemplate = envGraphiteTemplate
+	}
+	if envGraphiteConns, ok := os.LookupEnv("GRAPHITE_MAX_CONNS"); ok {
+
+		graphiteConns, _ = strconv.ParseInt(envGraphiteConns, 10, 64)
+	}
+
+	if envGRPCAddress, ok := os.LookupEnv("GRPC_ADDRESS"); ok {
+
+		grpcAddress = envGRPCAddress
+	}
+
+	webhookURLs := make([]string, 0)
+	for _, url := range strings.Split(webhooks, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			webhookURLs = append(webhookURLs, url)
+		}
+	}
+
+	return &Config{
+		FlagRunAddr:       flagRunAddr,
+		LogLevel:          logLevel,
+		LogOutputPath:     logOutputPath,
+		LogErrorPath:      logErrortPath,
+		StoreInterval:     storeInterval,
+		FileStoragePath:   fileStoragePath,
+		Restore:           restore,
+		DBstring:          dbString,
+		HashKey:           rawKey,
+		RulesFile:         rulesFile,
+		AlertInterval:     alertInterval,
+		Webhooks:          webhookURLs,
+		HistoryRetention:  historyRetention,
+		IdempotencyWindow: idempotencyWindow,
+		StatsdAddress:     statsdAddress,
+		StatsdFlush:       statsdFlush,
+		GraphiteAddress:   graphiteAddress,
+		GraphiteTemplate:  graphiteTemplate,
+		GraphiteConns:     graphiteConns,
+		GRPCAddress:       grpcAddress,
+	}
 }
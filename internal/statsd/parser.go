@@ -0,0 +1,93 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// StatsD metric kinds
+const (
+	kindCounter = "c"
+	kindGauge   = "g"
+	kindTimer   = "ms"
+	kindHisto   = "h"
+)
+
+// line is one parsed StatsD line name:value|type[|@rate][|#tag:value,...]
+type line struct {
+	name     string
+	kind     string
+	value    float64
+	rate     float64
+	relative bool
+	labels   map[string]string
+}
+
+func parseLine(raw string) (line, error) {
+	l := line{rate: 1}
+
+	nameEnd := strings.LastIndexByte(strings.SplitN(raw, "|", 2)[0], ':')
+	if nameEnd <= 0 {
+		return line{}, fmt.Errorf("wrong line %q", raw)
+	}
+	l.name = raw[:nameEnd]
+
+	parts := strings.Split(raw[nameEnd+1:], "|")
+	if len(parts) < 2 {
+		return line{}, fmt.Errorf("no type in line %q", raw)
+	}
+
+	l.kind = parts[1]
+	switch l.kind {
+	case kindCounter, kindGauge, kindTimer, kindHisto:
+	default:
+		return line{}, fmt.Errorf("unsupported type %q", l.kind)
+	}
+
+	rawValue := parts[0]
+	// gauge with sign is a change of current value
+	if l.kind == kindGauge && (strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-")) {
+		l.relative = true
+	}
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return line{}, fmt.Errorf("wrong value %q", rawValue)
+	}
+	l.value = value
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || !(rate > 0 && rate <= 1) {
+				return line{}, fmt.Errorf("wrong sample rate %q", part)
+			}
+			l.rate = rate
+		case strings.HasPrefix(part, "#"):
+			l.labels = parseTags(part[1:])
+		case part == "":
+		default:
+			return line{}, errors.New("unknown line section " + part)
+		}
+	}
+	return l, nil
+}
+
+// parseTags parses DogStatsD tags, tag without value gets empty one
+func parseTags(raw string) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range strings.Split(raw, ",") {
+		if tag == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(tag, ":")
+		labels[name] = value
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
@@ -0,0 +1,240 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+)
+
+const maxPacketSize = 65535
+
+type gauge struct {
+	metric metrics.Metrics
+	value  float64
+	set    bool
+}
+
+type counter struct {
+	metric metrics.Metrics
+	value  float64
+}
+
+// Server receives StatsD lines over UDP and writes aggregates to storage every flush interval.
+// Counters become counters, gauges become gauges, timers become summaries
+type Server struct {
+	log   logger.Logger
+	stor  storage.MetricsStorer
+	flush time.Duration
+	conn  net.PacketConn
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	timers   map[string]*metrics.Metrics
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewServer opens UDP socket on address
+func NewServer(address string, flush time.Duration, stor storage.MetricsStorer, log logger.Logger) (*Server, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		log:      log,
+		stor:     stor,
+		flush:    flush,
+		conn:     conn,
+		counters: make(map[string]*counter),
+		gauges:   make(map[string]*gauge),
+		timers:   make(map[string]*metrics.Metrics),
+		done:     make(chan struct{}),
+	}
+	// listener and flush loop of RunServer, counted here so Shutdown started
+	// before RunServer still waits for them
+	s.wg.Add(2)
+	return s, nil
+}
+
+// Addr returns address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RunServer reads packets until Shutdown, it must be called once for every server
+func (s *Server) RunServer() {
+	defer s.wg.Done()
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.flush)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), s.flush)
+				if err := s.Flush(ctx); err != nil {
+					s.log.Errorf("Error flushing statsd metrics %s", err)
+				}
+				cancel()
+			}
+		}
+	}()
+
+	s.log.Infof("Starting statsd listener on %s", s.conn.LocalAddr())
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Errorf("statsd reading error %s", err)
+			continue
+		}
+		s.handlePacket(string(buf[:n]))
+	}
+}
+
+func (s *Server) handlePacket(packet string) {
+	for _, raw := range strings.Split(packet, "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		l, err := parseLine(raw)
+		if err != nil {
+			s.log.Errorf("statsd parsing error %s", err)
+			continue
+		}
+		s.add(l)
+	}
+}
+
+func (s *Server) add(l line) {
+	metric := metrics.Metrics{ID: l.name, Labels: l.labels}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch l.kind {
+	case kindCounter:
+		metric.MType = "counter"
+		key := metric.Key()
+		c, ok := s.counters[key]
+		if !ok {
+			c = &counter{metric: metric}
+			s.counters[key] = c
+		}
+		c.value += l.value / l.rate
+	case kindGauge:
+		metric.MType = "gauge"
+		key := metric.Key()
+		g, ok := s.gauges[key]
+		if !ok {
+			g = &gauge{metric: metric}
+			s.gauges[key] = g
+		}
+		if l.relative {
+			g.value += l.value
+		} else {
+			g.value = l.value
+			g.set = true
+		}
+	case kindTimer, kindHisto:
+		metric.MType = "summary"
+		key := metric.Key()
+		t, ok := s.timers[key]
+		if !ok {
+			var sum float64
+			metric.Sketch = &metrics.Sketch{}
+			metric.Sum = &sum
+			t = &metric
+			s.timers[key] = t
+		}
+		t.Sketch.Add(l.value)
+		*t.Sum += l.value
+	}
+}
+
+// Flush writes metrics aggregated since previous flush to storage.
+// Metrics are stored one by one if storage allows, failed ones are logged and error is returned only if none is stored
+func (s *Server) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	counters, gauges, timers := s.counters, s.gauges, s.timers
+	s.counters = make(map[string]*counter)
+	s.gauges = make(map[string]*gauge)
+	s.timers = make(map[string]*metrics.Metrics)
+	s.mu.Unlock()
+
+	batch := make([]metrics.Metrics, 0, len(counters)+len(gauges)+len(timers))
+	for _, c := range counters {
+		delta := int64(math.Round(c.value))
+		c.metric.Delta = &delta
+		batch = append(batch, c.metric)
+	}
+	for _, g := range gauges {
+		value := g.value
+		// change without absolute value in window applies to stored gauge
+		if !g.set {
+			if stored, ok := s.stor.Get(ctx, g.metric); ok && stored.MType == "gauge" && stored.Value != nil {
+				value += *stored.Value
+			}
+		}
+		g.metric.Value = &value
+		batch = append(batch, g.metric)
+	}
+	for _, t := range timers {
+		batch = append(batch, *t)
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+	setter, ok := s.stor.(storage.BatchSetter)
+	if !ok {
+		if err := s.stor.SetAll(ctx, batch); err != nil {
+			return err
+		}
+		s.log.Infof("Flushed %d statsd metrics", len(batch))
+		return nil
+	}
+
+	// metric that conflicts with stored one must not drop the rest of window
+	_, errs := setter.SetEach(ctx, batch)
+	var lastErr error
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			s.log.Errorf("Cann't store statsd metric %s: %s", batch[i].ID, err)
+			lastErr = err
+			failed++
+		}
+	}
+	s.log.Infof("Flushed %d statsd metrics, %d failed", len(batch)-failed, failed)
+	if failed == len(batch) {
+		return lastErr
+	}
+	return nil
+}
+
+// Shutdown stops listener and flushes what was received
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.done)
+	err := s.conn.Close()
+	s.wg.Wait()
+	if flushErr := s.Flush(ctx); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    line
+		wantErr bool
+	}{
+		{
+			name: "counter",
+			raw:  "requests:1|c",
+			want: line{name: "requests", kind: kindCounter, value: 1, rate: 1},
+		},
+		{
+			name: "sampled counter with tags",
+			raw:  "requests:2|c|@0.5|#host:a,env:prod",
+			want: line{name: "requests", kind: kindCounter, value: 2, rate: 0.5, labels: map[string]string{"host": "a", "env": "prod"}},
+		},
+		{
+			name: "relative gauge",
+			raw:  "queue:-3|g",
+			want: line{name: "queue", kind: kindGauge, value: -3, rate: 1, relative: true},
+		},
+		{
+			name: "timer",
+			raw:  "db.query:12.5|ms",
+			want: line{name: "db.query", kind: kindTimer, value: 12.5, rate: 1},
+		},
+		{
+			name:    "set is not supported",
+			raw:     "users:42|s",
+			wantErr: true,
+		},
+		{
+			name:    "no type",
+			raw:     "requests:1",
+			wantErr: true,
+		},
+		{
+			name:    "wrong value",
+			raw:     "requests:abc|c",
+			wantErr: true,
+		},
+		{
+			name:    "wrong rate",
+			raw:     "requests:1|c|@2",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(tt.raw)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServer(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "./log.txt")
+	require.NoError(t, err)
+	value := 10.0
+	stor, err := storage.NewMemStorage(map[string]metrics.Metrics{
+		"queue": {ID: "queue", MType: "gauge", Value: &value},
+	}, false, "", Log)
+	require.NoError(t, err)
+
+	srv, err := NewServer("127.0.0.1:0", time.Hour, stor, Log)
+	require.NoError(t, err)
+	go srv.RunServer()
+
+	conn, err := net.Dial("udp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	packets := []string{
+		"requests:1|c\nrequests:2|c|@0.5",
+		"queue:+5|g\nqueue:-2|g",
+		// counter over stored gauge is rejected alone
+		"queue:1|c",
+		"temp:20|g|#room:a",
+		"db.query:10|ms\ndb.query:30|ms",
+		"broken line",
+	}
+	for _, p := range packets {
+		_, err := conn.Write([]byte(p))
+		require.NoError(t, err)
+	}
+
+	ctx := context.Background()
+	require.Eventually(t, func() bool {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		return len(srv.timers) == 1 && len(srv.gauges) == 2 && srv.counters["requests"] != nil && srv.counters["requests"].value == 5 && srv.counters["queue"] != nil
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, srv.Shutdown(ctx))
+
+	requests, ok := stor.Get(ctx, metrics.Metrics{ID: "requests", MType: "counter"})
+	require.True(t, ok)
+	assert.Equal(t, int64(5), *requests.Delta)
+
+	queue, ok := stor.Get(ctx, metrics.Metrics{ID: "queue", MType: "gauge"})
+	require.True(t, ok)
+	assert.Equal(t, 13.0, *queue.Value)
+
+	temp, ok := stor.Get(ctx, metrics.Metrics{ID: "temp", MType: "gauge", Labels: map[string]string{"room": "a"}})
+	require.True(t, ok)
+	assert.Equal(t, 20.0, *temp.Value)
+
+	query, ok := stor.Get(ctx, metrics.Metrics{ID: "db.query", MType: "summary"})
+	require.True(t, ok)
+	assert.Equal(t, int64(2), *query.Count)
+	assert.Equal(t, 40.0, *query.Sum)
+}
+
+func TestShutdownBeforeRun(t *testing.T) {
+	Log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", Log)
+	require.NoError(t, err)
+
+	srv, err := NewServer("127.0.0.1:0", time.Millisecond, stor, Log)
+	require.NoError(t, err)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- srv.Shutdown(context.Background())
+	}()
+
+	// Shutdown waits for RunServer even if it is not started yet
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown returned before RunServer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go srv.RunServer()
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown didn't return")
+	}
+}
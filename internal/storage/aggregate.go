@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// Functions of range query
+const (
+	FuncAvg      = "avg"
+	FuncMin      = "min"
+	FuncMax      = "max"
+	FuncSum      = "sum"
+	FuncCount    = "count"
+	FuncLast     = "last"
+	FuncRate     = "rate"
+	FuncIncrease = "increase"
+)
+
+// ValidFunc checks that function can be evaluated over samples of metric type,
+// rate and increase need cumulative values of counters, histograms and summaries
+func ValidFunc(fn, mtype string) error {
+	switch fn {
+	case FuncAvg, FuncMin, FuncMax, FuncSum, FuncCount, FuncLast:
+		return nil
+	case FuncRate, FuncIncrease:
+		if mtype == "gauge" {
+			return errors.New(fn + " is not defined for gauge")
+		}
+		return nil
+	}
+	return errors.New("wrong function " + fn)
+}
+
+// Evaluate returns fn of samples in window (t-window, t] for every t stepped from `from` to `to`,
+// only `to` is evaluated when step is 0. Windows without samples are skipped.
+// Samples must be sorted, the last sample before window is baseline of rate and increase,
+// lower value than previous one means counter reset. Evaluation stops when ctx is done
+func Evaluate(ctx context.Context, samples []Sample, fn string, from, to time.Time, step, window time.Duration) ([]Sample, error) {
+	res := make([]Sample, 0)
+	if step <= 0 {
+		from = to
+		step = time.Nanosecond
+	}
+
+	for t := from; !t.After(to); t = t.Add(step) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		start := firstAfter(samples, t.Add(-window))
+		end := firstAfter(samples, t)
+		if start == end {
+			continue
+		}
+
+		var value float64
+		switch fn {
+		case FuncRate, FuncIncrease:
+			if start > 0 {
+				start--
+			}
+			if end-start < 2 {
+				continue
+			}
+			value = increase(samples[start:end])
+			if fn == FuncRate {
+				value /= window.Seconds()
+			}
+		default:
+			value = aggregate(samples[start:end], fn)
+		}
+		res = append(res, Sample{Time: t, Value: value})
+	}
+	return res, nil
+}
+
+// increase sums growth of cumulative values, after reset value counts from zero
+func increase(samples []Sample) float64 {
+	var res float64
+	for i := 1; i < len(samples); i++ {
+		d := samples[i].Value - samples[i-1].Value
+		if d < 0 {
+			d = samples[i].Value
+		}
+		res += d
+	}
+	return res
+}
+
+// aggregate evaluates fn over non-empty samples
+func aggregate(samples []Sample, fn string) float64 {
+	switch fn {
+	case FuncCount:
+		return float64(len(samples))
+	case FuncLast:
+		return samples[len(samples)-1].Value
+	}
+
+	sum := 0.0
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range samples {
+		sum += s.Value
+		lo = math.Min(lo, s.Value)
+		hi = math.Max(hi, s.Value)
+	}
+	switch fn {
+	case FuncMin:
+		return lo
+	case FuncMax:
+		return hi
+	case FuncAvg:
+		return sum / float64(len(samples))
+	}
+	return sum
+}
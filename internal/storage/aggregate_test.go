@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluate(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(sec int, v float64) Sample {
+		return Sample{Time: start.Add(time.Duration(sec) * time.Second), Value: v}
+	}
+	samples := []Sample{at(1, 4), at(5, 2), at(9, 6), at(12, 10), at(15, 3), at(19, 7)}
+
+	tests := []struct {
+		name   string
+		fn     string
+		step   time.Duration
+		window time.Duration
+		want   []float64
+	}{
+		{name: "avg per step", fn: FuncAvg, step: 10 * time.Second, window: 10 * time.Second, want: []float64{4, 20.0 / 3}},
+		{name: "min", fn: FuncMin, step: 10 * time.Second, window: 10 * time.Second, want: []float64{2, 3}},
+		{name: "max", fn: FuncMax, step: 10 * time.Second, window: 10 * time.Second, want: []float64{6, 10}},
+		{name: "sum", fn: FuncSum, step: 10 * time.Second, window: 10 * time.Second, want: []float64{12, 20}},
+		{name: "count", fn: FuncCount, step: 10 * time.Second, window: 10 * time.Second, want: []float64{3, 3}},
+		{name: "last of whole range", fn: FuncLast, window: 20 * time.Second, want: []float64{7}},
+		{name: "increase with resets", fn: FuncIncrease, step: 10 * time.Second, window: 10 * time.Second, want: []float64{6, 11}},
+		{name: "rate", fn: FuncRate, window: 20 * time.Second, want: []float64{17.0 / 20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Evaluate(context.Background(), samples, tt.fn, start.Add(10*time.Second), start.Add(20*time.Second), tt.step, tt.window)
+			require.NoError(t, err)
+			values := make([]float64, 0, len(res))
+			for _, s := range res {
+				values = append(values, s.Value)
+			}
+			assert.InDeltaSlice(t, tt.want, values, 1e-9)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Evaluate(ctx, samples, FuncAvg, start, start.Add(time.Hour), time.Nanosecond, time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDeleteResetsCounterHistory(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+	stor, err := NewMemStorage(make(map[string]metrics.Metrics), false, "", log)
+	require.NoError(t, err)
+	stor.EnableHistory(time.Hour)
+
+	ctx := context.Background()
+	for _, d := range []int64{5, 5} {
+		delta := d
+		require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "c", MType: "counter", Delta: &delta}))
+	}
+	require.NoError(t, stor.Delete(ctx, metrics.Metrics{ID: "c", MType: "counter"}))
+	delta := int64(30)
+	require.NoError(t, stor.Set(ctx, metrics.Metrics{ID: "c", MType: "counter", Delta: &delta}))
+
+	samples, err := stor.History(ctx, metrics.Metrics{ID: "c", MType: "counter"}, time.Now().Add(-time.Minute), time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 4)
+
+	res, err := Evaluate(ctx, samples, FuncIncrease, time.Now(), time.Now(), 0, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, 35.0, res[0].Value)
+}
+
+func TestHistoryLimits(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name      string
+		retention time.Duration
+		adds      int
+		step      time.Duration
+		wantLen   int
+		wantFirst float64
+	}{
+		{
+			name:      "retention window",
+			retention: 10 * time.Second,
+			adds:      30,
+			step:      time.Second,
+			wantLen:   10,
+			wantFirst: 20,
+		},
+		{
+			name:      "samples cap",
+			retention: time.Hour,
+			adds:      maxHistorySamples + 5,
+			step:      time.Millisecond,
+			wantLen:   maxHistorySamples,
+			wantFirst: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHistory(tt.retention)
+			for i := 0; i < tt.adds; i++ {
+				h.add("g", start.Add(time.Duration(i)*tt.step), float64(i))
+			}
+
+			samples := h.get("g", start, start.Add(time.Duration(tt.adds)*tt.step))
+			require.Len(t, samples, tt.wantLen)
+			assert.Equal(t, tt.wantFirst, samples[0].Value)
+			assert.Equal(t, float64(tt.adds-1), samples[len(samples)-1].Value)
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// ErrWrongType is returned when metric conflicts with type of stored metric of the same name
+var ErrWrongType = errors.New("wrong type")
+
+// BatchSetter stores batches with result of every metric
+type BatchSetter interface {
+	// SetEach stores every metric on its own, failed metrics don't stop the rest.
+	// Stored values and errors are indexed like batch
+	SetEach(ctx context.Context, batch []metrics.Metrics) ([]metrics.Metrics, []error)
+	// SetAtomic stores all metrics of batch or none of them, failure is *BatchError
+	SetAtomic(ctx context.Context, batch []metrics.Metrics) ([]metrics.Metrics, error)
+}
+
+// BatchError is failure of metric at Index that made the whole batch rejected
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("metric %d: %s", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/metricserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStorageBatch(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	gauge := func(id string, v float64) metrics.Metrics {
+		return metrics.Metrics{ID: id, MType: "gauge", Value: &v}
+	}
+	counter := func(id string, d int64) metrics.Metrics {
+		return metrics.Metrics{ID: id, MType: "counter", Delta: &d}
+	}
+	// counter over stored gauge can't be merged
+	batch := []metrics.Metrics{counter("c", 2), counter("c", 3), counter("g", 1), gauge("h", 4)}
+
+	tests := []struct {
+		name       string
+		atomic     bool
+		wantErrs   []bool
+		wantDeltas map[string]int64
+		wantGauges map[string]float64
+	}{
+		{
+			name:       "each",
+			wantErrs:   []bool{false, false, true, false},
+			wantDeltas: map[string]int64{"c": 5},
+			wantGauges: map[string]float64{"g": 1, "h": 4},
+		},
+		{
+			name:       "atomic",
+			atomic:     true,
+			wantGauges: map[string]float64{"g": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.json")
+			stor, err := NewMemStorage(map[string]metrics.Metrics{"g": gauge("g", 1)}, true, path, log)
+			require.NoError(t, err)
+			ctx := context.Background()
+
+			if tt.atomic {
+				stored, err := stor.SetAtomic(ctx, batch)
+				var batchErr *BatchError
+				require.ErrorAs(t, err, &batchErr)
+				assert.Equal(t, 2, batchErr.Index)
+				assert.Nil(t, stored)
+			} else {
+				stored, errs := stor.SetEach(ctx, batch)
+				require.Len(t, errs, len(batch))
+				for i, wantErr := range tt.wantErrs {
+					assert.Equal(t, wantErr, errs[i] != nil, "metric %d", i)
+				}
+				assert.Equal(t, int64(2), *stored[0].Delta)
+				assert.Equal(t, int64(5), *stored[1].Delta)
+			}
+
+			// restored storage sees the same batch result
+			restored := make(map[string]metrics.Metrics)
+			require.NoError(t, metricserver.RestoreMetric(path, &restored, log))
+			require.NoError(t, RestoreWAL(WALPath(path), &restored, log))
+			for _, all := range []map[string]metrics.Metrics{stor.GetAll(ctx), restored} {
+				assert.Len(t, all, len(tt.wantDeltas)+len(tt.wantGauges))
+				for id, d := range tt.wantDeltas {
+					assert.Equal(t, d, *all[id].Delta, id)
+				}
+				for id, v := range tt.wantGauges {
+					assert.Equal(t, v, *all[id].Value, id)
+				}
+			}
+		})
+	}
+}
+
+func TestMemStorageWALFailure(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	d := int64(2)
+	batch := []metrics.Metrics{{ID: "c", MType: "counter", Delta: &d}}
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	stor, err := NewMemStorage(make(map[string]metrics.Metrics), true, path, log)
+	require.NoError(t, err)
+	stor.EnableIdempotency(time.Minute)
+	ctx := context.Background()
+
+	// batch that can't be logged is neither stored nor remembered by key
+	require.NoError(t, stor.file.Close())
+	stored, err := stor.SetAtomic(ctx, batch)
+	assert.Error(t, err)
+	assert.Nil(t, stored)
+	applied, err := stor.SetAllOnce(ctx, "a", batch)
+	assert.Error(t, err)
+	assert.True(t, applied)
+	assert.Empty(t, stor.GetAll(ctx))
+
+	stor.file, err = os.OpenFile(WALPath(path), os.O_APPEND|os.O_RDWR, 0666)
+	require.NoError(t, err)
+	stor.encoder = json.NewEncoder(stor.file)
+	applied, err = stor.SetAllOnce(ctx, "a", batch)
+	require.NoError(t, err)
+	assert.True(t, applied)
+	c, ok := stor.Get(ctx, batch[0])
+	require.True(t, ok)
+	assert.Equal(t, int64(2), *c.Delta)
+	require.NoError(t, stor.Close())
+}
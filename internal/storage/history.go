@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// Sample is a metric value at moment of time
+type Sample struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+// ErrHistoryDisabled is returned by History of storage that doesn't keep samples
+var ErrHistoryDisabled = errors.New("history is disabled")
+
+// MetricsHistorian returns stored samples of metric in time range [from, to]
+type MetricsHistorian interface {
+	History(ctx context.Context, metric metrics.Metrics, from, to time.Time) ([]Sample, error)
+}
+
+type HistoryPruner interface {
+	PruneHistory(ctx context.Context) error
+}
+
+// maxHistorySamples caps samples kept for one metric, the oldest are dropped first
+const maxHistorySamples = 10000
+
+// history keeps samples of every metric for retention window, at most maxHistorySamples per metric
+type history struct {
+	mu        sync.RWMutex
+	retention time.Duration
+	samples   map[string][]Sample
+}
+
+func newHistory(retention time.Duration) *history {
+	return &history{
+		retention: retention,
+		samples:   make(map[string][]Sample),
+	}
+}
+
+func (h *history) add(key string, t time.Time, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	samples := append(h.samples[key], Sample{Time: t, Value: value})
+	samples = samples[firstAfter(samples, t.Add(-h.retention)):]
+	if len(samples) > maxHistorySamples {
+		samples = samples[len(samples)-maxHistorySamples:]
+	}
+	h.samples[key] = samples
+}
+
+func (h *history) get(key string, from, to time.Time) []Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	samples := h.samples[key]
+	start := firstAfter(samples, from.Add(-time.Nanosecond))
+	end := firstAfter(samples, to)
+
+	res := make([]Sample, end-start)
+	copy(res, samples[start:end])
+	return res
+}
+
+func (h *history) prune(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := now.Add(-h.retention)
+	for key, samples := range h.samples {
+		samples = samples[firstAfter(samples, cutoff):]
+		if len(samples) == 0 {
+			delete(h.samples, key)
+			continue
+		}
+		h.samples[key] = samples
+	}
+}
+
+// firstAfter returns index of first sample later than t
+func firstAfter(samples []Sample, t time.Time) int {
+	return sort.Search(len(samples), func(i int) bool {
+		return samples[i].Time.After(t)
+	})
+}
+
+// sampleValue returns value stored in history for metric
+func sampleValue(metric metrics.Metrics) float64 {
+	switch metric.MType {
+	case "gauge":
+		if metric.Value != nil {
+			return *metric.Value
+		}
+	case "counter":
+		if metric.Delta != nil {
+			return float64(*metric.Delta)
+		}
+	case "histogram", "summary":
+		if metric.Count != nil {
+			return float64(*metric.Count)
+		}
+	}
+	return 0
+}
+
+// Downsample returns evenly stepped samples from `from` to `to`,
+// each point is the last sample at or before its time
+func Downsample(samples []Sample, from, to time.Time, step time.Duration) []Sample {
+	res := make([]Sample, 0)
+	if step <= 0 {
+		return res
+	}
+
+	i := 0
+	for t := from; !t.After(to); t = t.Add(step) {
+		for i < len(samples) && !samples[i].Time.After(t) {
+			i++
+		}
+		if i == 0 {
+			continue
+		}
+		res = append(res, Sample{Time: t, Value: samples[i-1].Value})
+	}
+	return res
+}
+
+func PruneHistory(stor HistoryPruner, interval time.Duration, log logger.Logger) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		if err := stor.PruneHistory(ctx); err != nil {
+			log.Errorf("Error pruning metrics history %s", err)
+		}
+		cancel()
+	}
+}
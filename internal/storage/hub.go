@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// MetricsNotifier lets readers follow changes of stored metrics,
+// StopNotifications ends all subscriptions before shutdown
+type MetricsNotifier interface {
+	Subscribe(filter MetricsFilter) (*Subscription, error)
+	StopNotifications()
+}
+
+// Hub delivers changed metrics to subscribers. Publish never waits for subscribers:
+// changes not yet taken by slow one are coalesced to the latest value of every metric
+type Hub struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives metrics matched by filter, sorting and paging of filter are ignored
+type Subscription struct {
+	hub    *Hub
+	match  func(metrics.Metrics) bool
+	ready  chan struct{}
+	done   chan struct{}
+	mu     sync.Mutex
+	order  []string
+	latest map[string]metrics.Metrics
+}
+
+// Subscribe starts delivering changes to new subscription until it is closed
+func (h *Hub) Subscribe(filter MetricsFilter) (*Subscription, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	match, _ := filter.matcher()
+	sub := &Subscription{
+		hub:    h,
+		match:  match,
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		latest: make(map[string]metrics.Metrics),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errors.New("notifications are stopped")
+	}
+	h.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Close ends all subscriptions and refuses new ones
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for sub := range h.subs {
+		close(sub.done)
+		delete(h.subs, sub)
+	}
+}
+
+// Publish hands changed metrics to subscribers
+func (h *Hub) Publish(changed ...metrics.Metrics) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		sub.push(changed)
+	}
+}
+
+func (s *Subscription) push(changed []metrics.Metrics) {
+	s.mu.Lock()
+	pushed := false
+	for _, m := range changed {
+		if !s.match(m) {
+			continue
+		}
+		key := m.MType + "/" + m.Key()
+		if _, ok := s.latest[key]; !ok {
+			s.order = append(s.order, key)
+		}
+		s.latest[key] = m
+		pushed = true
+	}
+	s.mu.Unlock()
+
+	if pushed {
+		select {
+		case s.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Ready is signaled when there are changes to take
+func (s *Subscription) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Done is closed when hub is closed
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Take returns changes since previous call in order of their first change
+func (s *Subscription) Take() []metrics.Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]metrics.Metrics, 0, len(s.order))
+	for _, key := range s.order {
+		res = append(res, s.latest[key])
+	}
+	s.order = nil
+	s.latest = make(map[string]metrics.Metrics)
+	return res
+}
+
+// Close stops delivering changes
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+}
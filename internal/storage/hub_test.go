@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub(t *testing.T) {
+	gauge := func(id string, v float64) metrics.Metrics {
+		return metrics.Metrics{ID: id, MType: "gauge", Value: &v}
+	}
+
+	hub := NewHub()
+	sub, err := hub.Subscribe(MetricsFilter{Glob: "cpu_*"})
+	require.NoError(t, err)
+	all, err := hub.Subscribe(MetricsFilter{})
+	require.NoError(t, err)
+
+	_, err = hub.Subscribe(MetricsFilter{Regex: "("})
+	assert.Error(t, err)
+
+	// nobody reads, publishing must not block and keeps latest values
+	for i := 0; i < 1000; i++ {
+		hub.Publish(gauge("cpu_user", float64(i)), gauge("mem_free", 1))
+	}
+	hub.Publish(gauge("cpu_system", 5))
+
+	<-sub.Ready()
+	changed := sub.Take()
+	require.Len(t, changed, 2)
+	assert.Equal(t, "cpu_user", changed[0].ID)
+	assert.Equal(t, 999.0, *changed[0].Value)
+	assert.Equal(t, "cpu_system", changed[1].ID)
+	assert.Empty(t, sub.Take())
+
+	<-all.Ready()
+	assert.Len(t, all.Take(), 3)
+
+	all.Close()
+	hub.Publish(gauge("cpu_user", 1))
+	assert.Empty(t, all.Take())
+
+	hub.Close()
+	<-sub.Done()
+	_, err = hub.Subscribe(MetricsFilter{})
+	assert.Error(t, err)
+}
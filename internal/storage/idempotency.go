@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// IdempotentSetter stores batch at most once per key within idempotency window
+type IdempotentSetter interface {
+	// SetAllOnce stores batch like SetAll, batch replayed with the same key is not stored and applied is false
+	SetAllOnce(ctx context.Context, key string, batch []metrics.Metrics) (applied bool, err error)
+}
+
+type IdempotencyPruner interface {
+	PruneIdempotencyKeys(ctx context.Context) error
+}
+
+// maxSeenKeys caps idempotency keys remembered in memory, the oldest are forgotten first
+const maxSeenKeys = 100000
+
+// seenKeys remembers keys for window but at most limit of them, order keeps keys from oldest to newest
+type seenKeys struct {
+	window time.Duration
+	limit  int
+	times  map[string]time.Time
+	order  []string
+}
+
+func newSeenKeys(window time.Duration, limit int) *seenKeys {
+	return &seenKeys{
+		window: window,
+		limit:  limit,
+		times:  make(map[string]time.Time),
+	}
+}
+
+func (k *seenKeys) seen(key string, now time.Time) bool {
+	k.prune(now)
+	_, ok := k.times[key]
+	return ok
+}
+
+func (k *seenKeys) add(key string, now time.Time) {
+	k.times[key] = now
+	k.order = append(k.order, key)
+
+	evicted := 0
+	for len(k.order)-evicted > k.limit {
+		delete(k.times, k.order[evicted])
+		evicted++
+	}
+	k.order = k.order[evicted:]
+}
+
+func (k *seenKeys) prune(now time.Time) {
+	cutoff := now.Add(-k.window)
+	expired := 0
+	for expired < len(k.order) && !k.times[k.order[expired]].After(cutoff) {
+		delete(k.times, k.order[expired])
+		expired++
+	}
+	k.order = k.order[expired:]
+}
+
+func PruneIdempotencyKeys(stor IdempotencyPruner, interval time.Duration, log logger.Logger) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		if err := stor.PruneIdempotencyKeys(ctx); err != nil {
+			log.Errorf("Error pruning idempotency keys %s", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStorageSetAllOnce(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	counter := func(id string, d int64) metrics.Metrics {
+		return metrics.Metrics{ID: id, MType: "counter", Delta: &d}
+	}
+	gauge := func(id string, v float64) metrics.Metrics {
+		return metrics.Metrics{ID: id, MType: "gauge", Value: &v}
+	}
+
+	tests := []struct {
+		name        string
+		window      time.Duration
+		keys        []string
+		batches     [][]metrics.Metrics
+		wantApplied []bool
+		wantErr     []bool
+		wantDelta   int64
+	}{
+		{
+			name:        "replay is skipped",
+			window:      time.Minute,
+			keys:        []string{"a", "a", "b"},
+			batches:     [][]metrics.Metrics{{counter("c", 2)}, {counter("c", 2)}, {counter("c", 3)}},
+			wantApplied: []bool{true, false, true},
+			wantErr:     []bool{false, false, false},
+			wantDelta:   5,
+		},
+		{
+			name:        "rejected batch is not remembered",
+			window:      time.Minute,
+			keys:        []string{"a", "a"},
+			batches:     [][]metrics.Metrics{{counter("c", 2), counter("g", 1)}, {counter("c", 2)}},
+			wantApplied: []bool{true, true},
+			wantErr:     []bool{true, false},
+			wantDelta:   2,
+		},
+		{
+			name:        "disabled",
+			keys:        []string{"a", "a"},
+			batches:     [][]metrics.Metrics{{counter("c", 2)}, {counter("c", 2)}},
+			wantApplied: []bool{true, true},
+			wantErr:     []bool{false, false},
+			wantDelta:   4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stor, err := NewMemStorage(map[string]metrics.Metrics{"g": gauge("g", 1)}, false, "", log)
+			require.NoError(t, err)
+			if tt.window > 0 {
+				stor.EnableIdempotency(tt.window)
+			}
+
+			ctx := context.Background()
+			for i, batch := range tt.batches {
+				applied, err := stor.SetAllOnce(ctx, tt.keys[i], batch)
+				assert.Equal(t, tt.wantApplied[i], applied, "batch %d", i)
+				assert.Equal(t, tt.wantErr[i], err != nil, "batch %d", i)
+			}
+			c, ok := stor.Get(ctx, counter("c", 0))
+			require.True(t, ok)
+			assert.Equal(t, tt.wantDelta, *c.Delta)
+		})
+	}
+}
+
+func TestSeenKeys(t *testing.T) {
+	start := time.Now()
+	keys := newSeenKeys(time.Minute, 10)
+
+	keys.add("a", start)
+	keys.add("b", start.Add(30*time.Second))
+
+	assert.True(t, keys.seen("a", start.Add(59*time.Second)))
+	assert.False(t, keys.seen("a", start.Add(time.Minute)))
+	assert.True(t, keys.seen("b", start.Add(time.Minute)))
+	assert.False(t, keys.seen("b", start.Add(2*time.Minute)))
+	assert.Empty(t, keys.times)
+	assert.Empty(t, keys.order)
+}
+
+func TestSeenKeysLimit(t *testing.T) {
+	start := time.Now()
+	keys := newSeenKeys(time.Minute, 2)
+
+	keys.add("a", start)
+	keys.add("b", start.Add(time.Second))
+	keys.add("c", start.Add(2*time.Second))
+
+	now := start.Add(3 * time.Second)
+	assert.False(t, keys.seen("a", now))
+	assert.True(t, keys.seen("b", now))
+	assert.True(t, keys.seen("c", now))
+	assert.Len(t, keys.times, 2)
+	assert.Equal(t, []string{"b", "c"}, keys.order)
+}
@@ -4,23 +4,29 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/Mr-Punder/go-alerting-service/internal/logger"
 	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
 )
 
-// MemStorage is simple implementation of storage metrics storage with map
+// MemStorage is simple implementation of storage metrics storage with map.
+// Metrics are persisted to snapshot file at path and write-ahead log next to it
 type MemStorage struct {
 	syncSave bool
 	log      logger.Logger
+	path     string
 	file     *os.File
 	encoder  *json.Encoder
+	walSize  int
 	mu       sync.Mutex
 	storage  map[string]metrics.Metrics
+	history  *history
+	keys     *seenKeys
+	hub      *Hub
 }
 
 func NewMemStorage(metrics map[string]metrics.Metrics, ss bool, path string, log logger.Logger) (*MemStorage, error) {
@@ -28,7 +34,7 @@ func NewMemStorage(metrics map[string]metrics.Metrics, ss bool, path string, log
 	var err error
 
 	if path != "" {
-		file, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
+		file, err = os.OpenFile(WALPath(path), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
 		if err != nil {
 			log.Infof("cann't open file %s", err)
 			log.Infof("Trying to create dir %s", filepath.Dir(path))
@@ -37,7 +43,7 @@ func NewMemStorage(metrics map[string]metrics.Metrics, ss bool, path string, log
 				log.Errorf("creating directory %s", err)
 				return nil, err
 			}
-			file, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
+			file, err = os.OpenFile(WALPath(path), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
 			if err != nil {
 				log.Errorf("cann't open file %s", err)
 				return nil, err
@@ -47,16 +53,56 @@ func NewMemStorage(metrics map[string]metrics.Metrics, ss bool, path string, log
 		log.Info("File opened")
 	}
 
-	return &MemStorage{
+	stor := &MemStorage{
 		syncSave: ss,
 		log:      log,
+		path:     path,
 		storage:  metrics,
 		file:     file,
 		encoder:  json.NewEncoder(file),
-	}, nil
+		hub:      NewHub(),
+	}
+
+	if path != "" {
+		// start with snapshot of given metrics and empty log
+		if err := stor.compact(); err != nil {
+			log.Errorf("cann't write snapshot %s", err)
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return stor, nil
+}
+
+// EnableHistory makes storage keep samples of every metric for retention window
+func (stor *MemStorage) EnableHistory(retention time.Duration) {
+	stor.history = newHistory(retention)
+}
+
+// History returns samples of metric in time range
+func (stor *MemStorage) History(ctx context.Context, metric metrics.Metrics, from, to time.Time) ([]Sample, error) {
+	if stor.history == nil {
+		return nil, ErrHistoryDisabled
+	}
+	return stor.history.get(metric.Key(), from, to), nil
+}
+
+// PruneHistory drops samples older than retention window
+func (stor *MemStorage) PruneHistory(ctx context.Context) error {
+	if stor.history != nil {
+		stor.history.prune(time.Now())
+	}
+	return nil
 }
 
 func (stor *MemStorage) Close() error {
+	if stor.file == nil {
+		return nil
+	}
+	if err := stor.Save(context.Background()); err != nil {
+		stor.log.Errorf("Error saving metrics on close %s", err)
+	}
 	err := stor.file.Close()
 	if err != nil {
 		stor.log.Errorf("Error closing file", err)
@@ -70,46 +116,188 @@ func (stor *MemStorage) Ping() error {
 	return nil
 }
 
-// GetAll returns map with all metrics
+// GetAll returns copy of map with all metrics
 func (stor *MemStorage) GetAll(ctx context.Context) map[string]metrics.Metrics {
+	stor.mu.Lock()
+	defer stor.mu.Unlock()
 	if stor.storage == nil {
 		stor.storage = make(map[string]metrics.Metrics)
 	}
-	return stor.storage
+	all := make(map[string]metrics.Metrics, len(stor.storage))
+	for key, metric := range stor.storage {
+		all[key] = metric
+	}
+	return all
+}
+
+// Query returns metrics selected by filter
+func (stor *MemStorage) Query(ctx context.Context, filter MetricsFilter) ([]metrics.Metrics, error) {
+	return FilterMetrics(stor.GetAll(ctx), filter)
 }
 
 // Set stores metric
 func (stor *MemStorage) Set(ctx context.Context, metric metrics.Metrics) error {
+	_, err := stor.SetAtomic(ctx, []metrics.Metrics{metric})
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		return batchErr.Err
+	}
+	return err
+}
+
+// SetEach stores metrics one by one, failed metric doesn't stop the rest
+func (stor *MemStorage) SetEach(ctx context.Context, batch []metrics.Metrics) ([]metrics.Metrics, []error) {
+	stored := make([]metrics.Metrics, len(batch))
+	errs := make([]error, len(batch))
+	for i, metric := range batch {
+		merged, err := stor.SetAtomic(ctx, []metrics.Metrics{metric})
+		if len(merged) == 1 {
+			stored[i] = merged[0]
+		}
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			err = batchErr.Err
+		}
+		errs[i] = err
+	}
+	return stored, errs
+}
+
+// SetAtomic merges whole batch aside and applies it only if every metric is merged.
+// Batch is written to write-ahead log as one entry, so it is never replayed partially
+func (stor *MemStorage) SetAtomic(ctx context.Context, batch []metrics.Metrics) ([]metrics.Metrics, error) {
+	stor.mu.Lock()
+	defer stor.mu.Unlock()
+	merged, err := stor.apply(batch)
+	stor.notify(merged)
+	return merged, err
+}
+
+// SetAllOnce stores batch like SetAll unless the same key was stored within idempotency window.
+// Keys are kept in memory only, so they are forgotten on restart
+func (stor *MemStorage) SetAllOnce(ctx context.Context, key string, batch []metrics.Metrics) (bool, error) {
+	if stor.keys == nil {
+		return true, stor.SetAll(ctx, batch)
+	}
+
+	now := time.Now()
+	stor.mu.Lock()
+	defer stor.mu.Unlock()
+	if stor.keys.seen(key, now) {
+		return false, nil
+	}
+	merged, err := stor.apply(batch)
+	if merged != nil {
+		stor.keys.add(key, now)
+	}
+	stor.notify(merged)
+	return true, err
+}
+
+// EnableIdempotency makes SetAllOnce remember keys for window, at most maxSeenKeys of them
+func (stor *MemStorage) EnableIdempotency(window time.Duration) {
+	stor.keys = newSeenKeys(window, maxSeenKeys)
+}
+
+// apply merges whole batch aside and stores it only if every metric is merged,
+// merged is nil if batch is not stored. Must be called with mu locked
+func (stor *MemStorage) apply(batch []metrics.Metrics) ([]metrics.Metrics, error) {
 	if stor.storage == nil {
 		stor.storage = make(map[string]metrics.Metrics)
 	}
-	if metric.MType == "gauge" {
-		stor.mu.Lock()
-		stor.storage[metric.ID] = metric
-		stor.mu.Unlock()
 
-	} else if metric.MType == "counter" {
-		stor.mu.Lock()
+	staged := make(map[string]metrics.Metrics, len(batch))
+	merged := make([]metrics.Metrics, len(batch))
+	for i, metric := range batch {
+		key := metric.Key()
+		st, ok := staged[key]
+		if !ok {
+			st, ok = stor.storage[key]
+		}
+		m, err := merge(st, ok, metric)
+		if err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		staged[key] = m
+		merged[i] = m
+	}
 
-		if st, ok := stor.storage[metric.ID]; ok {
-			*st.Delta += *metric.Delta
-			stor.storage[metric.ID] = st
-		} else {
-			stor.storage[metric.ID] = metric
+	if stor.syncSave {
+		// batch that is not logged is not stored, so storage never gets ahead of log
+		entry := walEntry{Op: walBatch, Batch: merged}
+		if len(merged) == 1 {
+			entry = walEntry{Op: walSet, Metric: merged[0]}
+		}
+		if err := stor.writeWAL(entry); err != nil {
+			return nil, err
 		}
-		stor.mu.Unlock()
+	}
+	for key, m := range staged {
+		stor.storage[key] = m
+	}
 
-	} else {
-		return errors.New("wrong type")
+	// batch is already logged, failed compaction is retried on next write
+	if err := stor.compactFull(); err != nil {
+		stor.log.Errorf("compact wal %s", err)
+	}
+	return merged, nil
+}
 
+// notify records stored metrics in history and publishes them to subscribers.
+// Must be called with mu locked, so concurrent updates of one key are seen in order
+func (stor *MemStorage) notify(merged []metrics.Metrics) {
+	if len(merged) == 0 {
+		return
 	}
-	if stor.syncSave {
-		err := stor.Save(ctx)
-		if err != nil {
-			return err
+	if stor.history != nil {
+		now := time.Now()
+		for _, m := range merged {
+			stor.history.add(m.Key(), now, sampleValue(m))
 		}
 	}
-	return nil
+	stor.hub.Publish(merged...)
+}
+
+// merge returns value of metric after update, exists tells whether stored value is present
+func merge(stored metrics.Metrics, exists bool, metric metrics.Metrics) (metrics.Metrics, error) {
+	if !metrics.ValidType(metric.MType) {
+		return metrics.Metrics{}, ErrWrongType
+	}
+	if exists && metric.MType != "gauge" && stored.MType != metric.MType {
+		return metrics.Metrics{}, ErrWrongType
+	}
+
+	switch metric.MType {
+	case "counter":
+		if metric.Delta == nil {
+			return metrics.Metrics{}, &metrics.FieldError{Field: "delta", Message: "counter without delta"}
+		}
+		if exists {
+			sum := *stored.Delta + *metric.Delta
+			stored.Delta = &sum
+			return stored, nil
+		}
+	case "histogram":
+		if exists {
+			return metrics.MergeHistograms(stored, metric)
+		}
+	case "summary":
+		if !exists {
+			stored = metrics.Metrics{}
+		}
+		return metrics.MergeSummaries(stored, metric), nil
+	}
+	return metric, nil
+}
+
+// Subscribe follows changes made by Set and SetAll
+func (stor *MemStorage) Subscribe(filter MetricsFilter) (*Subscription, error) {
+	return stor.hub.Subscribe(filter)
+}
+
+// StopNotifications ends all subscriptions
+func (stor *MemStorage) StopNotifications() {
+	stor.hub.Close()
 }
 
 // Get returns one metric  and it's existence
@@ -118,50 +306,85 @@ func (stor *MemStorage) Get(ctx context.Context, metric metrics.Metrics) (metric
 	if stor.storage == nil {
 		stor.storage = make(map[string]metrics.Metrics)
 	}
-	if metric.MType != "gauge" && metric.MType != "counter" {
+	if !metrics.ValidType(metric.MType) {
 		return metrics.Metrics{}, false
 	}
-	m, ok := stor.storage[metric.ID]
+	stor.mu.Lock()
+	m, ok := stor.storage[metric.Key()]
+	stor.mu.Unlock()
 	return m, ok
 }
 
-// Delete deletes one gauge by name and do nothibg if the metric does not exist
+// Delete deletes one gauge by name and labels and do nothibg if the metric does not exist
 func (stor *MemStorage) Delete(ctx context.Context, metric metrics.Metrics) error {
 	if stor.storage == nil {
 		stor.storage = make(map[string]metrics.Metrics)
 	}
-	delete(stor.storage, metric.ID)
-	return nil
+	stor.mu.Lock()
+	defer stor.mu.Unlock()
+	if stor.syncSave {
+		if err := stor.writeWAL(walEntry{Op: walDelete, Metric: metric}); err != nil {
+			return err
+		}
+	}
+	key := metric.Key()
+	if st, ok := stor.storage[key]; ok && stor.history != nil && st.MType != "gauge" {
+		// recreated metric counts from zero, so history gets reset sample
+		stor.history.add(key, time.Now(), 0)
+	}
+	delete(stor.storage, key)
+	return stor.compactFull()
 }
 
+// Save writes snapshot of all metrics and truncates write-ahead log
 func (stor *MemStorage) Save(ctx context.Context) error {
-	if _, err := stor.file.Seek(0, io.SeekStart); err != nil {
-		return err
-	}
-	err := stor.file.Truncate(0)
-	if err != nil {
-		stor.log.Errorf("Truncate file %s", err)
+	stor.mu.Lock()
+	defer stor.mu.Unlock()
+	return stor.compact()
+}
+
+// compact must be called with mu locked
+func (stor *MemStorage) compact() error {
+	if err := writeSnapshot(stor.path, stor.storage); err != nil {
+		stor.log.Errorf("encode metrics %s", err)
 		return err
 	}
 
-	stor.mu.Lock()
-	err = stor.encoder.Encode(stor.storage)
-	if err != nil {
-		stor.log.Errorf("encode metrics %s", err)
+	// snapshot is already on disk, so losing log from here on is safe
+	if err := stor.file.Truncate(0); err != nil {
+		stor.log.Errorf("Truncate file %s", err)
 		return err
 	}
-	stor.mu.Unlock()
+	stor.walSize = 0
 	stor.log.Info("Metrics saved")
 	return nil
 }
 
-func (stor *MemStorage) SetAll(ctx context.Context, metrics []metrics.Metrics) error {
-	for _, metric := range metrics {
-		err := stor.Set(ctx, metric)
-		if err != nil {
-			return err
-		}
+// compactFull compacts log grown to walCompactSize entries, must be called with mu locked
+// after logged change is applied, so snapshot includes it
+func (stor *MemStorage) compactFull() error {
+	if !stor.syncSave || stor.walSize < walCompactSize {
+		return nil
 	}
+	return stor.compact()
+}
 
+// writeWAL must be called with mu locked, change is applied after it is logged
+func (stor *MemStorage) writeWAL(entry walEntry) error {
+	if err := stor.encoder.Encode(entry); err != nil {
+		stor.log.Errorf("encode wal entry %s", err)
+		return err
+	}
+	if err := stor.file.Sync(); err != nil {
+		stor.log.Errorf("sync wal %s", err)
+		return err
+	}
+	stor.walSize++
 	return nil
 }
+
+// SetAll stores all metrics of batch or none of them
+func (stor *MemStorage) SetAll(ctx context.Context, batch []metrics.Metrics) error {
+	_, err := stor.SetAtomic(ctx, batch)
+	return err
+}
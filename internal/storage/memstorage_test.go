@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/metricserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStorageWAL(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	gauge := func(id string, v float64) metrics.Metrics {
+		return metrics.Metrics{ID: id, MType: "gauge", Value: &v}
+	}
+	counter := func(id string, d int64) metrics.Metrics {
+		return metrics.Metrics{ID: id, MType: "counter", Delta: &d}
+	}
+
+	restore := func(t *testing.T, path string) map[string]metrics.Metrics {
+		met := make(map[string]metrics.Metrics)
+		require.NoError(t, metricserver.RestoreMetric(path, &met, log))
+		require.NoError(t, RestoreWAL(WALPath(path), &met, log))
+		return met
+	}
+
+	tests := []struct {
+		name        string
+		updates     []metrics.Metrics
+		deletes     []metrics.Metrics
+		save        bool
+		brokenTail  bool
+		wantGauges  map[string]float64
+		wantCounter map[string]int64
+	}{
+		{
+			name:        "replay without snapshot",
+			updates:     []metrics.Metrics{gauge("g", 1), counter("c", 2), gauge("g", 3), counter("c", 5)},
+			wantGauges:  map[string]float64{"g": 3},
+			wantCounter: map[string]int64{"c": 7},
+		},
+		{
+			name:        "snapshot and wal",
+			updates:     []metrics.Metrics{counter("c", 2), gauge("g", 1), counter("c", 3), gauge("g", 4)},
+			save:        true,
+			wantGauges:  map[string]float64{"g": 4},
+			wantCounter: map[string]int64{"c": 5},
+		},
+		{
+			name:        "delete",
+			updates:     []metrics.Metrics{counter("c", 2), gauge("g", 1)},
+			deletes:     []metrics.Metrics{gauge("g", 0)},
+			wantGauges:  map[string]float64{},
+			wantCounter: map[string]int64{"c": 2},
+		},
+		{
+			name:        "broken tail",
+			updates:     []metrics.Metrics{counter("c", 2), counter("c", 3)},
+			brokenTail:  true,
+			wantGauges:  map[string]float64{},
+			wantCounter: map[string]int64{"c": 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.json")
+			stor, err := NewMemStorage(make(map[string]metrics.Metrics), true, path, log)
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			for i, m := range tt.updates {
+				require.NoError(t, stor.Set(ctx, m))
+				// snapshot in the middle to check that replay over it doesn't double counters
+				if tt.save && i == len(tt.updates)/2 {
+					require.NoError(t, stor.Save(ctx))
+				}
+			}
+			for _, m := range tt.deletes {
+				require.NoError(t, stor.Delete(ctx, m))
+			}
+			if tt.brokenTail {
+				_, err := stor.file.WriteString(`{"op":"set","metric":{"id":"c","ty`)
+				require.NoError(t, err)
+			}
+			// no Close: restore has to work after crash
+			require.NoError(t, stor.file.Close())
+
+			met := restore(t, path)
+			gauges := make(map[string]float64)
+			counters := make(map[string]int64)
+			for _, m := range met {
+				if m.MType == "gauge" {
+					gauges[m.ID] = *m.Value
+				} else {
+					counters[m.ID] = *m.Delta
+				}
+			}
+			assert.Equal(t, tt.wantGauges, gauges)
+			assert.Equal(t, tt.wantCounter, counters)
+		})
+	}
+
+	t.Run("snapshot rewritten on start", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		stor, err := NewMemStorage(map[string]metrics.Metrics{"c": counter("c", 4)}, true, path, log)
+		require.NoError(t, err)
+		require.NoError(t, stor.Close())
+
+		wal, err := os.ReadFile(WALPath(path))
+		require.NoError(t, err)
+		assert.Empty(t, wal)
+		assert.Equal(t, int64(4), *restore(t, path)["c"].Delta)
+	})
+}
@@ -3,6 +3,10 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Mr-Punder/go-alerting-service/internal/logger"
@@ -11,8 +15,15 @@ import (
 )
 
 type PostgreDB struct {
-	db  *sql.DB
-	log logger.Logger
+	db                *sql.DB
+	log               logger.Logger
+	retention         time.Duration
+	idempotencyWindow time.Duration
+	hub               *Hub
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
 func NewPostgreDB(dsn string, log logger.Logger) (*PostgreDB, error) {
@@ -27,6 +38,7 @@ func NewPostgreDB(dsn string, log logger.Logger) (*PostgreDB, error) {
 	Pdb := PostgreDB{
 		db:  db,
 		log: log,
+		hub: NewHub(),
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -62,14 +74,29 @@ func (db *PostgreDB) InitTable(ctx context.Context) error {
 
 	if existsTable {
 		db.log.Info("Found table")
-		return nil
+		if err := db.migrateLabels(ctx); err != nil {
+			return err
+		}
+		_, err = db.db.ExecContext(ctx, `
+			ALTER TABLE metric
+			ADD COLUMN IF NOT EXISTS buckets TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS sketch TEXT NOT NULL DEFAULT ''
+		`)
+		if err != nil {
+			db.log.Errorf("Error adding buckets and sketch columns %s", err)
+		}
+		return err
 	}
 	query = `
 		CREATE TABLE metric (
-			m_name VARCHAR(50) PRIMARY KEY,
+			m_name VARCHAR(50) NOT NULL,
+			labels TEXT NOT NULL DEFAULT '',
 			m_type VARCHAR(50) NOT NULL,
 			delta BIGINT,
-			value DOUBLE PRECISION
+			value DOUBLE PRECISION,
+			buckets TEXT NOT NULL DEFAULT '',
+			sketch TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (m_name, labels)
 		)
 	`
 
@@ -92,6 +119,93 @@ func (db *PostgreDB) InitTable(ctx context.Context) error {
 
 }
 
+// migrateLabels adds labels column to table created before labels support
+func (db *PostgreDB) migrateLabels(ctx context.Context) error {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM information_schema.columns
+			WHERE table_name = 'metric' AND column_name = 'labels'
+		)
+	`
+
+	var existsColumn bool
+	if err := db.db.QueryRowContext(ctx, query).Scan(&existsColumn); err != nil {
+		db.log.Errorf("Error searching labels column %s", err)
+		return err
+	}
+	if existsColumn {
+		return nil
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		db.log.Errorf("Error creating transaction %s", err)
+		return err
+	}
+	for _, query := range []string{
+		`ALTER TABLE metric ADD COLUMN labels TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE metric DROP CONSTRAINT IF EXISTS metric_pkey`,
+		`ALTER TABLE metric ADD PRIMARY KEY (m_name, labels)`,
+	} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			db.log.Errorf("Error migrating table metric %s", err)
+			tx.Rollback()
+			return err
+		}
+	}
+	db.log.Info("Table metric migrated to labels")
+	return tx.Commit()
+}
+
+// encodeLabels returns labels column value, sorted json or empty string for unlabeled metric
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func decodeLabels(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	if err := json.Unmarshal([]byte(raw), &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// fillMetric sets fields of scanned metric according to its type.
+// Histogram and summary keep count in delta column, sum in value column, buckets and sketch as json
+func fillMetric(metric *metrics.Metrics, buckets, sketch string) error {
+	switch metric.MType {
+	case "gauge":
+		metric.Delta = nil
+	case "histogram":
+		metric.Count, metric.Delta = metric.Delta, nil
+		metric.Sum, metric.Value = metric.Value, nil
+		if buckets != "" {
+			return json.Unmarshal([]byte(buckets), &metric.Buckets)
+		}
+	case "summary":
+		metric.Count, metric.Delta = metric.Delta, nil
+		metric.Sum, metric.Value = metric.Value, nil
+		if sketch != "" {
+			metric.Sketch = &metrics.Sketch{}
+			return json.Unmarshal([]byte(sketch), metric.Sketch)
+		}
+	default:
+		metric.Value = nil
+	}
+	return nil
+}
+
 func (db *PostgreDB) Close() error {
 	err := db.db.Close()
 	if err != nil {
@@ -106,7 +220,7 @@ func (db *PostgreDB) Ping() error {
 }
 
 func (db *PostgreDB) GetAll(ctx context.Context) map[string]metrics.Metrics {
-	query := `SELECT m_name, m_type, delta, value FROM metric`
+	query := `SELECT m_name, labels, m_type, delta, value, buckets, sketch FROM metric`
 
 	rows, err := db.db.QueryContext(ctx, query)
 	if err != nil {
@@ -124,36 +238,137 @@ func (db *PostgreDB) GetAll(ctx context.Context) map[string]metrics.Metrics {
 	metricMap := make(map[string]metrics.Metrics)
 
 	for rows.Next() {
-		var metric metrics.Metrics
-
-		err := rows.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value)
+		metric, err := scanMetric(rows)
 		if err != nil {
 			db.log.Errorf("Error scaning metric %s", err)
 			return make(map[string]metrics.Metrics)
 		}
+		metricMap[metric.Key()] = metric
+	}
 
-		if metric.MType == "gauge" {
-			metric.Delta = nil
-		} else {
-			metric.Value = nil
+	return metricMap
+}
+
+// scanMetric reads row of m_name, labels, m_type, delta, value, buckets, sketch
+func scanMetric(rows *sql.Rows) (metrics.Metrics, error) {
+	var metric metrics.Metrics
+	var labels, buckets, sketch string
+
+	if err := rows.Scan(&metric.ID, &labels, &metric.MType, &metric.Delta, &metric.Value, &buckets, &sketch); err != nil {
+		return metrics.Metrics{}, err
+	}
+	var err error
+	if metric.Labels, err = decodeLabels(labels); err != nil {
+		return metrics.Metrics{}, err
+	}
+	if err := fillMetric(&metric, buckets, sketch); err != nil {
+		return metrics.Metrics{}, err
+	}
+	return metric, nil
+}
+
+// Query selects metrics by filter in database, names are compared bytewise like in FilterMetrics
+func (db *PostgreDB) Query(ctx context.Context, filter MetricsFilter) ([]metrics.Metrics, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	conds := make([]string, 0)
+	args := make([]any, 0)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Type != "" {
+		conds = append(conds, "m_type = "+arg(filter.Type))
+	}
+	if filter.Prefix != "" {
+		p := arg(filter.Prefix)
+		conds = append(conds, fmt.Sprintf("left(m_name, char_length(%s)) = %s", p, p))
+	}
+	if filter.Glob != "" {
+		conds = append(conds, "m_name ~ "+arg(GlobToRegex(filter.Glob)))
+	}
+	if filter.Regex != "" {
+		conds = append(conds, "m_name ~ "+arg(filter.Regex))
+	}
+
+	desc := strings.HasPrefix(filter.Sort, "-")
+	columns := []string{`m_name COLLATE "C"`, `labels COLLATE "C"`, `m_type COLLATE "C"`}
+	if filter.Sort == SortType || filter.Sort == SortTypeDesc {
+		columns = []string{`m_type COLLATE "C"`, `m_name COLLATE "C"`, `labels COLLATE "C"`}
+	}
+
+	if filter.After != nil {
+		values := []string{arg(filter.After.ID), arg(filter.After.Labels), arg(filter.After.Type)}
+		if filter.Sort == SortType || filter.Sort == SortTypeDesc {
+			values = []string{values[2], values[0], values[1]}
 		}
-		metricMap[metric.ID] = metric
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		conds = append(conds, fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), op, strings.Join(values, ", ")))
 	}
 
-	return metricMap
+	query := "SELECT m_name, labels, m_type, delta, value, buckets, sketch FROM metric"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	order := make([]string, len(columns))
+	for i, col := range columns {
+		order[i] = col
+		if desc {
+			order[i] += " DESC"
+		}
+	}
+	query += " ORDER BY " + strings.Join(order, ", ")
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query += " OFFSET " + arg(filter.Offset)
+	}
+
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		db.log.Errorf("Error querying metrics %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]metrics.Metrics, 0)
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			db.log.Errorf("Error scaning metric %s", err)
+			return nil, err
+		}
+		res = append(res, metric)
+	}
+	return res, rows.Err()
 }
 func (db *PostgreDB) Get(ctx context.Context, metric metrics.Metrics) (metrics.Metrics, bool) {
 
 	query := `
-		SELECT m_name, m_type, delta, value
+		SELECT m_name, m_type, delta, value, buckets, sketch
 		FROM metric
-		WHERE m_name = $1
+		WHERE m_name = $1 AND labels = $2
 	`
-	id := metric.ID
+	id := metric.Key()
+
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		db.log.Errorf("Error encoding labels of metric %s %s", id, err)
+		return metrics.Metrics{}, false
+	}
 
-	var resMetric metrics.Metrics
+	resMetric := metrics.Metrics{Labels: metric.Labels}
+	var buckets, sketch string
 
-	err := db.db.QueryRowContext(ctx, query, id).Scan(&resMetric.ID, &resMetric.MType, &resMetric.Delta, &resMetric.Value)
+	err = db.db.QueryRowContext(ctx, query, metric.ID, labels).Scan(&resMetric.ID, &resMetric.MType, &resMetric.Delta, &resMetric.Value, &buckets, &sketch)
 	if err == sql.ErrNoRows {
 		db.log.Infof("Not found metric with id %s", id)
 		return metrics.Metrics{}, false
@@ -164,10 +379,9 @@ func (db *PostgreDB) Get(ctx context.Context, metric metrics.Metrics) (metrics.M
 		return metrics.Metrics{}, false
 	}
 
-	if resMetric.MType == "gauge" {
-		resMetric.Delta = nil
-	} else {
-		resMetric.Value = nil
+	if err := fillMetric(&resMetric, buckets, sketch); err != nil {
+		db.log.Errorf("Error decoding buckets or sketch of metric %s with error %s", id, err)
+		return metrics.Metrics{}, false
 	}
 
 	return resMetric, true
@@ -175,11 +389,18 @@ func (db *PostgreDB) Get(ctx context.Context, metric metrics.Metrics) (metrics.M
 }
 
 func (db *PostgreDB) Delete(ctx context.Context, metric metrics.Metrics) error {
-	quary := "DELETE FROM matric WHERE m_name = $1"
+	quary := "DELETE FROM metric WHERE m_name = $1 AND labels = $2"
+
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		return err
+	}
 
-	id := metric.ID
+	if err := db.addReset(ctx, metric.ID, labels); err != nil {
+		return err
+	}
 
-	_, err := db.db.ExecContext(ctx, quary, id)
+	_, err = db.db.ExecContext(ctx, quary, metric.ID, labels)
 	if err != nil {
 		db.log.Errorf("Error deleting meric %s  error: %s", metric.ID, err)
 		return err
@@ -187,13 +408,26 @@ func (db *PostgreDB) Delete(ctx context.Context, metric metrics.Metrics) error {
 	return nil
 }
 
+// upsertMetric stores gauge or counter, stored metric of other type is overwritten only by gauge.
+// Conflicting metric is not stored and no row is returned
+const upsertMetric = `
+	INSERT INTO metric (m_name, labels, m_type, delta, value)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (m_name, labels) DO update
+	SET  m_type = EXCLUDED.m_type, delta = metric.delta + EXCLUDED.delta, value = EXCLUDED.value
+	WHERE metric.m_type = EXCLUDED.m_type OR EXCLUDED.m_type = 'gauge'
+	RETURNING delta, value
+`
+
 func (db *PostgreDB) Set(ctx context.Context, metric metrics.Metrics) error {
-	quary := `
-		INSERT INTO metric (m_name, m_type, delta, value)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (m_name) DO update
-		SET  m_type = EXCLUDED.m_type, delta = metric.delta + EXCLUDED.delta, value = EXCLUDED.value
-	`
+	if metric.MType == "histogram" || metric.MType == "summary" {
+		_, err := db.SetAtomic(ctx, []metrics.Metrics{metric})
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			return batchErr.Err
+		}
+		return err
+	}
 
 	var delta int64
 	var value float64
@@ -208,36 +442,169 @@ func (db *PostgreDB) Set(ctx context.Context, metric metrics.Metrics) error {
 		value = *metric.Value
 	}
 
-	_, err := db.db.ExecContext(ctx, quary, metric.ID, metric.MType, delta, value)
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		db.log.Errorf("Error encoding labels of metric %s  error: %s", metric.ID, err)
+		return err
+	}
+
+	stored := metrics.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels}
+	err = db.db.QueryRowContext(ctx, upsertMetric, metric.ID, labels, metric.MType, delta, value).Scan(&stored.Delta, &stored.Value)
+	if err == sql.ErrNoRows {
+		err = ErrWrongType
+	}
 	if err != nil {
 		db.log.Errorf("Error updating metric %s  error: %s", metric.ID, err)
 		return err
 	}
 
+	if err := db.addHistory(ctx, db.db, metric.ID, labels); err != nil {
+		return err
+	}
+	fillMetric(&stored, "", "")
+	db.hub.Publish(stored)
 	return nil
 }
 
-func (db *PostgreDB) SetAll(ctx context.Context, metrics []metrics.Metrics) error {
+// SetAll stores all metrics of batch in one transaction
+func (db *PostgreDB) SetAll(ctx context.Context, batch []metrics.Metrics) error {
+	_, err := db.SetAtomic(ctx, batch)
+	return err
+}
+
+// SetEach stores every metric in its own transaction, failed metric doesn't stop the rest
+func (db *PostgreDB) SetEach(ctx context.Context, batch []metrics.Metrics) ([]metrics.Metrics, []error) {
+	stored := make([]metrics.Metrics, len(batch))
+	errs := make([]error, len(batch))
+	for i, metric := range batch {
+		merged, err := db.SetAtomic(ctx, []metrics.Metrics{metric})
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			err = batchErr.Err
+		}
+		if err == nil {
+			stored[i] = merged[0]
+		}
+		errs[i] = err
+	}
+	return stored, errs
+}
+
+// SetAtomic stores batch in one transaction and returns stored values,
+// error of any metric rolls back the whole batch
+func (db *PostgreDB) SetAtomic(ctx context.Context, batch []metrics.Metrics) ([]metrics.Metrics, error) {
 	tx, err := db.db.Begin()
 	if err != nil {
 		db.log.Errorf("Error creating transaction %s", err)
+		return nil, err
+	}
+
+	changed, err := db.setBatch(ctx, tx, batch)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	db.hub.Publish(changed...)
+	return changed, nil
+}
+
+// SetAllOnce stores batch like SetAll unless the same key was stored within idempotency window.
+// Key is inserted in the same transaction as batch, so concurrent replay waits for it and is skipped
+func (db *PostgreDB) SetAllOnce(ctx context.Context, key string, batch []metrics.Metrics) (bool, error) {
+	if db.idempotencyWindow == 0 {
+		return true, db.SetAll(ctx, batch)
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		db.log.Errorf("Error creating transaction %s", err)
+		return false, err
+	}
+
+	now := time.Now()
+	query := `DELETE FROM idempotency_key WHERE key = $1 AND created_at <= $2`
+	if _, err := tx.ExecContext(ctx, query, key, now.Add(-db.idempotencyWindow)); err != nil {
+		db.log.Errorf("Error deleting expired idempotency key %s", err)
+		tx.Rollback()
+		return false, err
+	}
+	query = `INSERT INTO idempotency_key (key, created_at) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING`
+	res, err := tx.ExecContext(ctx, query, key, now)
+	if err != nil {
+		db.log.Errorf("Error inserting idempotency key %s", err)
+		tx.Rollback()
+		return false, err
+	}
+	if inserted, err := res.RowsAffected(); err != nil || inserted == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	changed, err := db.setBatch(ctx, tx, batch)
+	if err != nil {
+		tx.Rollback()
+		return true, err
+	}
+	if err := tx.Commit(); err != nil {
+		return true, err
+	}
+	db.hub.Publish(changed...)
+	return true, nil
+}
+
+// EnableIdempotency creates idempotency_key table and makes SetAllOnce remember keys for window
+func (db *PostgreDB) EnableIdempotency(ctx context.Context, window time.Duration) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS idempotency_key (
+			key VARCHAR(64) PRIMARY KEY,
+			created_at TIMESTAMPTZ NOT NULL
+		)
+	`
+	if _, err := db.db.ExecContext(ctx, query); err != nil {
+		db.log.Errorf("Error creating table idempotency_key %s", err)
 		return err
 	}
 
-	stmt, err := tx.PrepareContext(ctx, `
-	INSERT INTO metric (m_name, m_type, delta, value)
-	VALUES ($1, $2, $3, $4)
-	ON CONFLICT (m_name) DO update
-	SET  m_type = EXCLUDED.m_type, delta = metric.delta + EXCLUDED.delta, value = EXCLUDED.value
-`)
+	query = `CREATE INDEX IF NOT EXISTS idempotency_key_created_idx ON idempotency_key (created_at)`
+	if _, err := db.db.ExecContext(ctx, query); err != nil {
+		db.log.Errorf("Error creating index on idempotency_key %s", err)
+		return err
+	}
+
+	db.idempotencyWindow = window
+	db.log.Info("table idempotency_key Initialized")
+	return nil
+}
+
+// PruneIdempotencyKeys drops keys older than idempotency window
+func (db *PostgreDB) PruneIdempotencyKeys(ctx context.Context) error {
+	if db.idempotencyWindow == 0 {
+		return nil
+	}
+
+	query := `DELETE FROM idempotency_key WHERE created_at <= $1`
+	if _, err := db.db.ExecContext(ctx, query, time.Now().Add(-db.idempotencyWindow)); err != nil {
+		db.log.Errorf("Error pruning idempotency_key %s", err)
+		return err
+	}
+	return nil
+}
+
+// setBatch stores batch inside transaction, caller rolls it back on error
+func (db *PostgreDB) setBatch(ctx context.Context, tx *sql.Tx, batch []metrics.Metrics) ([]metrics.Metrics, error) {
+	stmt, err := tx.PrepareContext(ctx, upsertMetric)
 
 	if err != nil {
 		db.log.Errorf("Error preparing query", err)
-		return err
+		return nil, err
 	}
 	defer stmt.Close()
 
-	for _, metric := range metrics {
+	changed := make([]metrics.Metrics, 0, len(batch))
+	for i, metric := range batch {
 		var delta int64
 		var value float64
 		if metric.Delta == nil {
@@ -251,12 +618,217 @@ func (db *PostgreDB) SetAll(ctx context.Context, metrics []metrics.Metrics) erro
 			value = *metric.Value
 		}
 
-		_, err := stmt.ExecContext(ctx, metric.ID, metric.MType, delta, value)
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			db.log.Errorf("Error encoding labels of metric %s  error: %s in transaction", metric.ID, err)
+			return nil, &BatchError{Index: i, Err: err}
+		}
+
+		stored := metrics.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels}
+		if metric.MType == "histogram" || metric.MType == "summary" {
+			stored, err = db.setMerged(ctx, tx, metric, labels)
+		} else {
+			err = stmt.QueryRowContext(ctx, metric.ID, labels, metric.MType, delta, value).Scan(&stored.Delta, &stored.Value)
+			if err == sql.ErrNoRows {
+				err = ErrWrongType
+			}
+			fillMetric(&stored, "", "")
+		}
 		if err != nil {
 			db.log.Errorf("Error updating metric %s  error: %s in transaction", metric.ID, err)
-			tx.Rollback()
-			return err
+			return nil, &BatchError{Index: i, Err: err}
+		}
+
+		if err := db.addHistory(ctx, tx, metric.ID, labels); err != nil {
+			return nil, err
 		}
+		changed = append(changed, stored)
 	}
-	return tx.Commit()
+	return changed, nil
+}
+
+// Subscribe follows changes made by Set and SetAll
+func (db *PostgreDB) Subscribe(filter MetricsFilter) (*Subscription, error) {
+	return db.hub.Subscribe(filter)
+}
+
+// StopNotifications ends all subscriptions
+func (db *PostgreDB) StopNotifications() {
+	db.hub.Close()
+}
+
+// EnableHistory creates metric_history table and makes Set record samples for retention window.
+// Every stored metric adds a metric_history row, so writes to database double while history is on
+func (db *PostgreDB) EnableHistory(ctx context.Context, retention time.Duration) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS metric_history (
+			m_name VARCHAR(50) NOT NULL,
+			labels TEXT NOT NULL DEFAULT '',
+			ts TIMESTAMPTZ NOT NULL,
+			value DOUBLE PRECISION NOT NULL
+		)
+	`
+	if _, err := db.db.ExecContext(ctx, query); err != nil {
+		db.log.Errorf("Error creating table metric_history %s", err)
+		return err
+	}
+
+	query = `CREATE INDEX IF NOT EXISTS metric_history_idx ON metric_history (m_name, labels, ts)`
+	if _, err := db.db.ExecContext(ctx, query); err != nil {
+		db.log.Errorf("Error creating index on metric_history %s", err)
+		return err
+	}
+
+	db.retention = retention
+	db.log.Info("table metric_history Initialized")
+	return nil
+}
+
+// addHistory copies current value of metric to metric_history
+func (db *PostgreDB) addHistory(ctx context.Context, ex execer, name, labels string) error {
+	if db.retention == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO metric_history (m_name, labels, ts, value)
+		SELECT m_name, labels, now(), CASE WHEN m_type = 'gauge' THEN value ELSE delta END
+		FROM metric
+		WHERE m_name = $1 AND labels = $2
+	`
+	if _, err := ex.ExecContext(ctx, query, name, labels); err != nil {
+		db.log.Errorf("Error adding history of metric %s  error: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+// addReset records zero sample of deleted cumulative metric, so recreated one is seen as reset
+func (db *PostgreDB) addReset(ctx context.Context, name, labels string) error {
+	if db.retention == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO metric_history (m_name, labels, ts, value)
+		SELECT m_name, labels, now(), 0
+		FROM metric
+		WHERE m_name = $1 AND labels = $2 AND m_type <> 'gauge'
+	`
+	if _, err := db.db.ExecContext(ctx, query, name, labels); err != nil {
+		db.log.Errorf("Error adding reset of metric %s  error: %s", name, err)
+		return err
+	}
+	return nil
+}
+
+func (db *PostgreDB) History(ctx context.Context, metric metrics.Metrics, from, to time.Time) ([]Sample, error) {
+	if db.retention == 0 {
+		return nil, ErrHistoryDisabled
+	}
+
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ts, value
+		FROM metric_history
+		WHERE m_name = $1 AND labels = $2 AND ts >= $3 AND ts <= $4
+		ORDER BY ts
+	`
+	rows, err := db.db.QueryContext(ctx, query, metric.ID, labels, from, to)
+	if err != nil {
+		db.log.Errorf("Error selecting history of metric %s %s", metric.ID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := make([]Sample, 0)
+	for rows.Next() {
+		var sample Sample
+		if err := rows.Scan(&sample.Time, &sample.Value); err != nil {
+			db.log.Errorf("Error scaning sample %s", err)
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// PruneHistory deletes samples older than retention window
+func (db *PostgreDB) PruneHistory(ctx context.Context) error {
+	if db.retention == 0 {
+		return nil
+	}
+
+	query := `DELETE FROM metric_history WHERE ts < $1`
+	if _, err := db.db.ExecContext(ctx, query, time.Now().Add(-db.retention)); err != nil {
+		db.log.Errorf("Error pruning metric_history %s", err)
+		return err
+	}
+	return nil
+}
+
+// setMerged merges histogram or summary with stored one inside transaction
+func (db *PostgreDB) setMerged(ctx context.Context, tx *sql.Tx, metric metrics.Metrics, labels string) (metrics.Metrics, error) {
+	query := `
+		SELECT m_name, m_type, delta, value, buckets, sketch
+		FROM metric
+		WHERE m_name = $1 AND labels = $2
+		FOR UPDATE
+	`
+	var stored metrics.Metrics
+	var buckets, sketch string
+	err := tx.QueryRowContext(ctx, query, metric.ID, labels).Scan(&stored.ID, &stored.MType, &stored.Delta, &stored.Value, &buckets, &sketch)
+	switch {
+	case err == sql.ErrNoRows:
+		stored = metrics.Metrics{}
+	case err != nil:
+		return metrics.Metrics{}, err
+	default:
+		if err := fillMetric(&stored, buckets, sketch); err != nil {
+			return metrics.Metrics{}, err
+		}
+		if stored.MType != metric.MType {
+			return metrics.Metrics{}, ErrWrongType
+		}
+	}
+
+	if metric.MType == "summary" {
+		metric = metrics.MergeSummaries(stored, metric)
+	} else if stored.MType != "" {
+		if metric, err = metrics.MergeHistograms(stored, metric); err != nil {
+			return metrics.Metrics{}, err
+		}
+	}
+
+	buckets, sketch = "", ""
+	if metric.Buckets != nil {
+		data, err := json.Marshal(metric.Buckets)
+		if err != nil {
+			return metrics.Metrics{}, err
+		}
+		buckets = string(data)
+	}
+	if metric.Sketch != nil {
+		data, err := json.Marshal(metric.Sketch)
+		if err != nil {
+			return metrics.Metrics{}, err
+		}
+		sketch = string(data)
+	}
+
+	query = `
+		INSERT INTO metric (m_name, labels, m_type, delta, value, buckets, sketch)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (m_name, labels) DO update
+		SET  m_type = EXCLUDED.m_type, delta = EXCLUDED.delta, value = EXCLUDED.value,
+			buckets = EXCLUDED.buckets, sketch = EXCLUDED.sketch
+	`
+	if _, err = tx.ExecContext(ctx, query, metric.ID, labels, metric.MType, metric.Count, metric.Sum, buckets, sketch); err != nil {
+		return metrics.Metrics{}, err
+	}
+	return metric, nil
 }
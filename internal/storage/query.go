@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// Sort orders of MetricsFilter, ties are broken by name and labels
+const (
+	SortName     = "name"
+	SortNameDesc = "-name"
+	SortType     = "type"
+	SortTypeDesc = "-type"
+)
+
+// Cursor is position of metric in sorted listing, Labels are encoded like in database
+type Cursor struct {
+	Type   string `json:"t"`
+	ID     string `json:"id"`
+	Labels string `json:"l"`
+}
+
+// CursorOf returns position of metric
+func CursorOf(metric metrics.Metrics) Cursor {
+	labels, _ := encodeLabels(metric.Labels)
+	return Cursor{Type: metric.MType, ID: metric.ID, Labels: labels}
+}
+
+// MetricsFilter selects, sorts and pages metrics. Empty fields don't filter
+type MetricsFilter struct {
+	Type   string
+	Prefix string
+	// Glob is shell pattern of name with *, ? and [...], [!...] negates class
+	Glob string
+	// Regex is limited to syntax shared by Go and PostgreSQL regular expressions, see checkPortable
+	Regex string
+	Sort  string
+	// After skips metrics up to cursor including it
+	After  *Cursor
+	Offset int
+	// Limit 0 means no limit
+	Limit int
+}
+
+// MetricsQuerier lists metrics by filter without loading all of them
+type MetricsQuerier interface {
+	Query(ctx context.Context, filter MetricsFilter) ([]metrics.Metrics, error)
+}
+
+// Validate checks sort order and patterns
+func (f MetricsFilter) Validate() error {
+	switch f.Sort {
+	case "", SortName, SortNameDesc, SortType, SortTypeDesc:
+	default:
+		return &metrics.FieldError{Field: "sort", Message: "wrong sort " + f.Sort}
+	}
+	if f.Type != "" && !metrics.ValidType(f.Type) {
+		return &metrics.FieldError{Field: "type", Message: "wrong type " + f.Type}
+	}
+	if f.Offset < 0 {
+		return &metrics.FieldError{Field: "offset", Message: "offset must not be negative"}
+	}
+	if f.Limit < 0 {
+		return &metrics.FieldError{Field: "limit", Message: "limit must not be negative"}
+	}
+	if _, err := f.regexps(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// regexps returns compiled glob and regex patterns
+func (f MetricsFilter) regexps() ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, 2)
+	if f.Glob != "" {
+		re, err := regexp.Compile(GlobToRegex(f.Glob))
+		if err != nil {
+			return nil, &metrics.FieldError{Field: "glob", Message: "wrong glob " + f.Glob}
+		}
+		res = append(res, re)
+	}
+	if f.Regex != "" {
+		if err := checkPortable(f.Regex); err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(f.Regex)
+		if err != nil {
+			return nil, &metrics.FieldError{Field: "regex", Message: "wrong regex " + f.Regex}
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// matcher returns check of type, prefix and patterns of filter
+func (f MetricsFilter) matcher() (func(metrics.Metrics) bool, error) {
+	patterns, err := f.regexps()
+	if err != nil {
+		return nil, err
+	}
+	return func(m metrics.Metrics) bool {
+		if f.Type != "" && m.MType != f.Type {
+			return false
+		}
+		if !strings.HasPrefix(m.ID, f.Prefix) {
+			return false
+		}
+		for _, re := range patterns {
+			if !re.MatchString(m.ID) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// checkPortable rejects Go syntax that PostgreSQL ~ operator doesn't accept or reads differently:
+// named groups, \p, \P, \z, \Q and \C escapes and flags other than leading (?i) or (?s)
+func checkPortable(pattern string) error {
+	unsupported := func(what string) error {
+		return &metrics.FieldError{Field: "regex", Message: "regex uses " + what + " not supported by every storage"}
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			if strings.IndexByte("pPzQC", pattern[i]) >= 0 {
+				return unsupported(`\` + string(pattern[i]))
+			}
+		case strings.HasPrefix(pattern[i:], "(?P<") || strings.HasPrefix(pattern[i:], "(?<"):
+			return unsupported("named group")
+		case strings.HasPrefix(pattern[i:], "(?") && !strings.HasPrefix(pattern[i:], "(?:"):
+			if i != 0 || !(strings.HasPrefix(pattern, "(?i)") || strings.HasPrefix(pattern, "(?s)")) {
+				return unsupported("flags")
+			}
+		}
+	}
+	return nil
+}
+
+// GlobToRegex converts shell pattern to anchored regular expression
+func GlobToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	inClass := false
+	classStart := false
+	for _, c := range glob {
+		switch {
+		case classStart && c == '!':
+			classStart = false
+			b.WriteByte('^')
+		case inClass:
+			classStart = false
+			if c == ']' {
+				inClass = false
+			}
+			b.WriteRune(c)
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteByte('.')
+		case c == '[':
+			inClass = true
+			classStart = true
+			b.WriteRune(c)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+// less compares positions according to sort order
+func less(a, b Cursor, order string) bool {
+	if order == SortType || order == SortTypeDesc {
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+	}
+	if a.ID != b.ID {
+		return a.ID < b.ID
+	}
+	if a.Labels != b.Labels {
+		return a.Labels < b.Labels
+	}
+	return a.Type < b.Type
+}
+
+// FilterMetrics applies filter to metrics in memory
+func FilterMetrics(all map[string]metrics.Metrics, filter MetricsFilter) ([]metrics.Metrics, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	match, _ := filter.matcher()
+	desc := strings.HasPrefix(filter.Sort, "-")
+
+	type item struct {
+		metric metrics.Metrics
+		pos    Cursor
+	}
+	items := make([]item, 0, len(all))
+	for _, m := range all {
+		if !match(m) {
+			continue
+		}
+		pos := CursorOf(m)
+		if filter.After != nil {
+			if !desc && !less(*filter.After, pos, filter.Sort) {
+				continue
+			}
+			if desc && !less(pos, *filter.After, filter.Sort) {
+				continue
+			}
+		}
+		items = append(items, item{metric: m, pos: pos})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if desc {
+			return less(items[j].pos, items[i].pos, filter.Sort)
+		}
+		return less(items[i].pos, items[j].pos, filter.Sort)
+	})
+
+	if filter.Offset >= len(items) {
+		return []metrics.Metrics{}, nil
+	}
+	items = items[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(items) {
+		items = items[:filter.Limit]
+	}
+
+	res := make([]metrics.Metrics, 0, len(items))
+	for _, it := range items {
+		res = append(res, it.metric)
+	}
+	return res, nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterMetrics(t *testing.T) {
+	value := 1.0
+	delta := int64(1)
+	all := make(map[string]metrics.Metrics)
+	for _, m := range []metrics.Metrics{
+		{ID: "cpu_user", MType: "gauge", Value: &value},
+		{ID: "cpu_system", MType: "gauge", Value: &value},
+		{ID: "cpu_user", MType: "gauge", Value: &value, Labels: map[string]string{"host": "a"}},
+		{ID: "requests", MType: "counter", Delta: &delta},
+		{ID: "mem_free", MType: "gauge", Value: &value},
+	} {
+		all[m.Key()] = m
+	}
+
+	type result struct {
+		ID   string
+		Host string
+	}
+	tests := []struct {
+		name    string
+		filter  MetricsFilter
+		want    []result
+		wantErr bool
+	}{
+		{
+			name:   "all by name",
+			filter: MetricsFilter{},
+			want:   []result{{"cpu_system", ""}, {"cpu_user", ""}, {"cpu_user", "a"}, {"mem_free", ""}, {"requests", ""}},
+		},
+		{
+			name:   "by type descending",
+			filter: MetricsFilter{Sort: SortTypeDesc, Limit: 2},
+			want:   []result{{"mem_free", ""}, {"cpu_user", "a"}},
+		},
+		{
+			name:   "counters first",
+			filter: MetricsFilter{Sort: SortType, Limit: 2},
+			want:   []result{{"requests", ""}, {"cpu_system", ""}},
+		},
+		{
+			name:   "prefix and glob",
+			filter: MetricsFilter{Prefix: "cpu", Glob: "*_u?er"},
+			want:   []result{{"cpu_user", ""}, {"cpu_user", "a"}},
+		},
+		{
+			name:   "negated glob class",
+			filter: MetricsFilter{Glob: "cpu_[!u]*"},
+			want:   []result{{"cpu_system", ""}},
+		},
+		{
+			name:   "leading flags",
+			filter: MetricsFilter{Regex: "(?i)^CPU_S"},
+			want:   []result{{"cpu_system", ""}},
+		},
+		{
+			name:    "named group is not portable",
+			filter:  MetricsFilter{Regex: "(?P<n>cpu)"},
+			wantErr: true,
+		},
+		{
+			name:    "flags inside are not portable",
+			filter:  MetricsFilter{Regex: "cpu(?i)_S"},
+			wantErr: true,
+		},
+		{
+			name:    "unicode class is not portable",
+			filter:  MetricsFilter{Regex: `\pL+`},
+			wantErr: true,
+		},
+		{
+			name:   "regex and offset",
+			filter: MetricsFilter{Regex: "^(cpu|mem)_", Offset: 1, Limit: 2},
+			want:   []result{{"cpu_user", ""}, {"cpu_user", "a"}},
+		},
+		{
+			name:   "after cursor",
+			filter: MetricsFilter{After: &Cursor{Type: "gauge", ID: "cpu_user"}},
+			want:   []result{{"cpu_user", "a"}, {"mem_free", ""}, {"requests", ""}},
+		},
+		{
+			name:   "after cursor descending",
+			filter: MetricsFilter{Sort: SortNameDesc, After: &Cursor{Type: "gauge", ID: "cpu_user", Labels: `{"host":"a"}`}},
+			want:   []result{{"cpu_user", ""}, {"cpu_system", ""}},
+		},
+		{
+			name:    "wrong regex",
+			filter:  MetricsFilter{Regex: "("},
+			wantErr: true,
+		},
+		{
+			name:    "wrong sort",
+			filter:  MetricsFilter{Sort: "value"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := FilterMetrics(all, tt.filter)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			got := make([]result, 0, len(res))
+			for _, m := range res {
+				got = append(got, result{m.ID, m.Labels["host"]})
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -56,6 +56,28 @@ func NewStorage(conf *config.Config, log logger.Logger) (MetricsStorer, func() e
 		}
 		log.Infof("Database is opened with dsn %s", conf.DBstring)
 
+		if conf.HistoryRetention > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			if err := db.EnableHistory(ctx, time.Duration(conf.HistoryRetention)*time.Second); err != nil {
+				log.Errorf("Error enabling metrics history %s", err)
+			} else {
+				go PruneHistory(db, time.Minute, log)
+				log.Info("Started history pruning goroutine")
+			}
+		}
+
+		if conf.IdempotencyWindow > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			if err := db.EnableIdempotency(ctx, time.Duration(conf.IdempotencyWindow)*time.Second); err != nil {
+				log.Errorf("Error enabling idempotency keys %s", err)
+			} else {
+				go PruneIdempotencyKeys(db, time.Minute, log)
+				log.Info("Started idempotency keys pruning goroutine")
+			}
+		}
+
 		return db, db.Close, nil
 	}
 	met := make(map[string]metrics.Metrics, 0)
@@ -63,6 +85,9 @@ func NewStorage(conf *config.Config, log logger.Logger) (MetricsStorer, func() e
 		if err := metricserver.RestoreMetric(conf.FileStoragePath, &met, log); err != nil {
 			log.Errorf("failed to restor metrics %s", err)
 		}
+		if err := RestoreWAL(WALPath(conf.FileStoragePath), &met, log); err != nil {
+			log.Errorf("failed to replay wal %s", err)
+		}
 
 	}
 
@@ -74,6 +99,16 @@ func NewStorage(conf *config.Config, log logger.Logger) (MetricsStorer, func() e
 	}
 	log.Info("Storage created")
 
+	if conf.HistoryRetention > 0 {
+		stor.EnableHistory(time.Duration(conf.HistoryRetention) * time.Second)
+		go PruneHistory(stor, time.Minute, log)
+		log.Info("Started history pruning goroutine")
+	}
+
+	if conf.IdempotencyWindow > 0 {
+		stor.EnableIdempotency(time.Duration(conf.IdempotencyWindow) * time.Second)
+	}
+
 	if conf.StoreInterval > 0 && conf.FileStoragePath != "" {
 		go SaveMetrics(stor, conf.StoreInterval, log)
 		log.Info("Started metric saving goroutine")
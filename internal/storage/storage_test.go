@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// typedStorage is storage checked by testTypeConflicts
+type typedStorage interface {
+	BatchSetter
+	Set(ctx context.Context, metric metrics.Metrics) error
+	Get(ctx context.Context, metric metrics.Metrics) (metrics.Metrics, bool)
+}
+
+func TestTypeConflicts(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	t.Run("memory", func(t *testing.T) {
+		stor, err := NewMemStorage(make(map[string]metrics.Metrics), false, "", log)
+		require.NoError(t, err)
+		testTypeConflicts(t, stor)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("DATABASE_DSN")
+		if dsn == "" {
+			t.Skip("DATABASE_DSN is not set")
+		}
+		db, err := NewPostgreDB(dsn, log)
+		require.NoError(t, err)
+		defer db.Close()
+		testTypeConflicts(t, db)
+	})
+}
+
+// testTypeConflicts checks that metric of other type than stored one is rejected unless it is gauge
+func testTypeConflicts(t *testing.T, stor typedStorage) {
+	// names are unique, so shared database is not cleaned up
+	suffix := fmt.Sprintf("_%d", time.Now().UnixNano())
+	counter := func(id string, d int64) metrics.Metrics {
+		return metrics.Metrics{ID: id + suffix, MType: "counter", Delta: &d}
+	}
+	gauge := func(id string, v float64) metrics.Metrics {
+		return metrics.Metrics{ID: id + suffix, MType: "gauge", Value: &v}
+	}
+	ctx := context.Background()
+
+	require.NoError(t, stor.Set(ctx, gauge("g", 1)))
+	require.NoError(t, stor.Set(ctx, counter("c", 2)))
+	require.NoError(t, stor.Set(ctx, metrics.NewHistogram("h"+suffix, metrics.DefaultBuckets, 1)))
+
+	tests := []struct {
+		name    string
+		metric  metrics.Metrics
+		wantErr bool
+		want    metrics.Metrics
+	}{
+		{
+			name:    "counter over gauge",
+			metric:  counter("g", 1),
+			wantErr: true,
+			want:    gauge("g", 1),
+		},
+		{
+			name:    "counter over histogram",
+			metric:  counter("h", 1),
+			wantErr: true,
+		},
+		{
+			name:    "histogram over counter",
+			metric:  metrics.NewHistogram("c"+suffix, metrics.DefaultBuckets, 1),
+			wantErr: true,
+			want:    counter("c", 2),
+		},
+		{
+			name:   "counter over counter",
+			metric: counter("c", 3),
+			want:   counter("c", 5),
+		},
+		{
+			name:   "gauge over counter",
+			metric: gauge("c", 4),
+			want:   gauge("c", 4),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := stor.Set(ctx, tt.metric)
+			if !tt.wantErr {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrWrongType)
+			}
+			if tt.want.ID == "" {
+				return
+			}
+			got, ok := stor.Get(ctx, tt.want)
+			require.True(t, ok)
+			assert.Equal(t, tt.want.MType, got.MType)
+			if tt.want.Delta != nil {
+				assert.Equal(t, *tt.want.Delta, *got.Delta)
+			}
+			if tt.want.Value != nil {
+				assert.Equal(t, *tt.want.Value, *got.Value)
+			}
+		})
+	}
+
+	t.Run("atomic batch", func(t *testing.T) {
+		_, err := stor.SetAtomic(ctx, []metrics.Metrics{counter("n", 1), counter("g", 1)})
+		var batchErr *BatchError
+		require.ErrorAs(t, err, &batchErr)
+		assert.Equal(t, 1, batchErr.Index)
+		assert.ErrorIs(t, err, ErrWrongType)
+		_, ok := stor.Get(ctx, counter("n", 0))
+		assert.False(t, ok)
+	})
+
+	t.Run("each of batch", func(t *testing.T) {
+		_, errs := stor.SetEach(ctx, []metrics.Metrics{counter("g", 1), gauge("g", 2)})
+		require.Len(t, errs, 2)
+		assert.ErrorIs(t, errs[0], ErrWrongType)
+		assert.NoError(t, errs[1])
+	})
+}
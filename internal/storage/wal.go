@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+const (
+	walSet    = "set"
+	walDelete = "delete"
+	// walBatch stores all metrics of Batch, batch is applied atomically
+	walBatch = "batch"
+
+	// walCompactSize is number of WAL entries after which snapshot is rewritten
+	walCompactSize = 1000
+)
+
+// walEntry is one line of write-ahead log. Metric holds stored value, not the update,
+// so replaying the log over a snapshot that already contains it is harmless
+type walEntry struct {
+	Op     string            `json:"op"`
+	Metric metrics.Metrics   `json:"metric"`
+	Batch  []metrics.Metrics `json:"batch,omitempty"`
+}
+
+// WALPath returns path of write-ahead log for snapshot path
+func WALPath(path string) string {
+	return path + ".wal"
+}
+
+// RestoreWAL replays write-ahead log over metrics restored from snapshot.
+// Broken last line, left by crash during write, is skipped
+func RestoreWAL(path string, met *map[string]metrics.Metrics, log logger.Logger) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Errorf("Cann't open file %s", path)
+		return err
+	}
+	defer file.Close()
+
+	if *met == nil {
+		*met = make(map[string]metrics.Metrics)
+	}
+
+	replayed := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry walEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Errorf("Skipping broken wal entry %s", err)
+			continue
+		}
+		switch entry.Op {
+		case walSet:
+			(*met)[entry.Metric.Key()] = entry.Metric
+		case walDelete:
+			delete(*met, entry.Metric.Key())
+		case walBatch:
+			for _, m := range entry.Batch {
+				(*met)[m.Key()] = m
+			}
+		}
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Errorf("Error reading wal %s", err)
+		return err
+	}
+
+	log.Infof("Replayed %d wal entries from %s", replayed, path)
+	return nil
+}
+
+// writeSnapshot atomically replaces file at path with metrics
+func writeSnapshot(path string, met map[string]metrics.Metrics) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(met); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
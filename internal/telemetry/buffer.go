@@ -0,0 +1,109 @@
+package telemetry
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// Gauge aggregation modes of Buffer
+const (
+	GaugeLast = "last"
+	GaugeMin  = "min"
+	GaugeMax  = "max"
+	GaugeAvg  = "avg"
+)
+
+type aggregate struct {
+	metric metrics.Metrics
+	sum    float64
+	count  int
+}
+
+// Buffer accumulates polled metrics between reports.
+// Counter deltas are summed, gauges are aggregated according to mode
+type Buffer struct {
+	mu        sync.Mutex
+	gaugeMode string
+	order     []string
+	aggr      map[string]*aggregate
+}
+
+func NewBuffer(gaugeMode string) (*Buffer, error) {
+	switch gaugeMode {
+	case GaugeLast, GaugeMin, GaugeMax, GaugeAvg:
+	default:
+		return nil, fmt.Errorf("unknown gauge aggregation %s", gaugeMode)
+	}
+	return &Buffer{
+		gaugeMode: gaugeMode,
+		aggr:      make(map[string]*aggregate),
+	}, nil
+}
+
+// Add merges polled batch into buffer
+func (b *Buffer) Add(batch []metrics.Metrics) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, m := range batch {
+		key := m.Key()
+		a, ok := b.aggr[key]
+		if !ok {
+			a = &aggregate{metric: metrics.Metrics{ID: m.ID, MType: m.MType, Labels: m.Labels}}
+			b.aggr[key] = a
+			b.order = append(b.order, key)
+		}
+
+		switch m.MType {
+		case "counter":
+			if m.Delta == nil {
+				continue
+			}
+			var delta int64
+			if a.metric.Delta != nil {
+				delta = *a.metric.Delta
+			}
+			delta += *m.Delta
+			a.metric.Delta = &delta
+		case "gauge":
+			if m.Value == nil {
+				continue
+			}
+			value := *m.Value
+			a.sum += value
+			a.count++
+			if a.metric.Value != nil {
+				switch b.gaugeMode {
+				case GaugeMin:
+					value = math.Min(value, *a.metric.Value)
+				case GaugeMax:
+					value = math.Max(value, *a.metric.Value)
+				case GaugeAvg:
+					value = a.sum / float64(a.count)
+				}
+			}
+			a.metric.Value = &value
+		}
+	}
+}
+
+// Flush returns accumulated metrics in order of first appearance and empties buffer
+func (b *Buffer) Flush() []metrics.Metrics {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	res := make([]metrics.Metrics, 0, len(b.order))
+	for _, key := range b.order {
+		a := b.aggr[key]
+		if a.metric.Delta == nil && a.metric.Value == nil {
+			continue
+		}
+		res = append(res, a.metric)
+	}
+	b.order = nil
+	b.aggr = make(map[string]*aggregate)
+	return res
+}
@@ -0,0 +1,298 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// Collector is a source of agent metrics
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) ([]metrics.Metrics, error)
+}
+
+// Registry keeps collectors polled by Telemetry
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+	enabled    map[string]bool
+	// self are agent self-metrics, they are never disabled
+	self map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collector, names must be unique
+func (r *Registry) Register(c Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.registered(c.Name()) {
+		return fmt.Errorf("collector %s is already registered", c.Name())
+	}
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+// registerSelf adds collector of agent self-metrics that stays enabled after Enable
+func (r *Registry) registerSelf(c Collector) error {
+	if err := r.Register(c); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.self == nil {
+		r.self = make(map[string]bool)
+	}
+	r.self[c.Name()] = true
+	return nil
+}
+
+// Enable leaves only collectors with given names enabled, without names all collectors are enabled.
+// Unregistered name is an error and leaves enabled collectors unchanged
+func (r *Registry) Enable(names ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(names) == 0 {
+		r.enabled = nil
+		return nil
+	}
+	enabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !r.registered(name) {
+			return fmt.Errorf("collector %s is not registered", name)
+		}
+		enabled[name] = true
+	}
+	r.enabled = enabled
+	return nil
+}
+
+// registered must be called with mu locked
+func (r *Registry) registered(name string) bool {
+	for _, c := range r.collectors {
+		if c.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Collectors returns enabled collectors
+func (r *Registry) Collectors() []Collector {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := make([]Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		if r.enabled == nil || r.enabled[c.Name()] || r.self[c.Name()] {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// RuntimeCollector collects Go runtime memory statistics, PollCount and RandomValue
+type RuntimeCollector struct{}
+
+func NewRuntimeCollector() *RuntimeCollector {
+	return &RuntimeCollector{}
+}
+
+func (c *RuntimeCollector) Name() string {
+	return "runtime"
+}
+
+// Collect returns slice of current runtime metrics
+func (c *RuntimeCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	memStats := runtime.MemStats{}
+	runtime.ReadMemStats(&memStats)
+
+	Alloc := float64(memStats.Alloc)
+	BuckHashSys := float64(memStats.BuckHashSys)
+	Frees := float64(memStats.Frees)
+	GCCPUFraction := memStats.GCCPUFraction
+	GCSys := float64(memStats.GCSys)
+	HeapAlloc := float64(memStats.HeapAlloc)
+	HeapIdle := float64(memStats.HeapIdle)
+	HeapInuse := float64(memStats.HeapInuse)
+	HeapObjects := float64(memStats.HeapObjects)
+	HeapReleased := float64(memStats.HeapReleased)
+	HeapSys := float64(memStats.HeapSys)
+	LastGC := float64(memStats.LastGC)
+	Lookups := float64(memStats.Lookups)
+	MCacheInuse := float64(memStats.MCacheInuse)
+	MCacheSys := float64(memStats.MCacheSys)
+	MSpanInuse := float64(memStats.MSpanInuse)
+	MSpanSys := float64(memStats.MSpanSys)
+	Mallocs := float64(memStats.Mallocs)
+	NextGC := float64(memStats.NextGC)
+	NumForcedGC := float64(memStats.NumForcedGC)
+	NumGC := float64(uint64(memStats.NumGC))
+	OtherSys := float64(memStats.OtherSys)
+	PauseTotalNs := float64(memStats.PauseTotalNs)
+	StackInuse := float64(memStats.StackInuse)
+	StackSys := float64(memStats.StackSys)
+	Sys := float64(memStats.Sys)
+	TotalAlloc := float64(memStats.TotalAlloc)
+	var PollCount int64 = 1
+	RandomValue := rand.Float64()
+
+	metr := []metrics.Metrics{
+		{
+			ID:    "Alloc",
+			MType: "gauge",
+			Value: &Alloc,
+		},
+		{
+			ID:    "BuckHashSys",
+			MType: "gauge",
+			Value: &BuckHashSys,
+		},
+		{
+			ID:    "Frees",
+			MType: "gauge",
+			Value: &Frees,
+		},
+		{
+			ID:    "GCCPUFraction",
+			MType: "gauge",
+			Value: &GCCPUFraction,
+		},
+		{
+			ID:    "GCSys",
+			MType: "gauge",
+			Value: &GCSys,
+		},
+		{
+			ID:    "HeapAlloc",
+			MType: "gauge",
+			Value: &HeapAlloc,
+		},
+		{
+			ID:    "HeapIdle",
+			MType: "gauge",
+			Value: &HeapIdle,
+		},
+		{
+			ID:    "HeapInuse",
+			MType: "gauge",
+			Value: &HeapInuse,
+		},
+		{
+			ID:    "HeapObjects",
+			MType: "gauge",
+			Value: &HeapObjects,
+		},
+		{
+			ID:    "HeapReleased",
+			MType: "gauge",
+			Value: &HeapReleased,
+		},
+		{
+			ID:    "HeapSys",
+			MType: "gauge",
+			Value: &HeapSys,
+		},
+		{
+			ID:    "LastGC",
+			MType: "gauge",
+			Value: &LastGC,
+		},
+		{
+			ID:    "Lookups",
+			MType: "gauge",
+			Value: &Lookups,
+		},
+		{
+			ID:    "MCacheInuse",
+			MType: "gauge",
+			Value: &MCacheInuse,
+		},
+		{
+			ID:    "MCacheSys",
+			MType: "gauge",
+			Value: &MCacheSys,
+		},
+		{
+			ID:    "MSpanInuse",
+			MType: "gauge",
+			Value: &MSpanInuse,
+		},
+		{
+			ID:    "MSpanSys",
+			MType: "gauge",
+			Value: &MSpanSys,
+		},
+		{
+			ID:    "Mallocs",
+			MType: "gauge",
+			Value: &Mallocs,
+		},
+		{
+			ID:    "NextGC",
+			MType: "gauge",
+			Value: &NextGC,
+		},
+		{
+			ID:    "NumForcedGC",
+			MType: "gauge",
+			Value: &NumForcedGC,
+		},
+		{
+			ID:    "NumGC",
+			MType: "gauge",
+			Value: &NumGC,
+		},
+		{
+			ID:    "OtherSys",
+			MType: "gauge",
+			Value: &OtherSys,
+		},
+		{
+			ID:    "PauseTotalNs",
+			MType: "gauge",
+			Value: &PauseTotalNs,
+		},
+		{
+			ID:    "StackInuse",
+			MType: "gauge",
+			Value: &StackInuse,
+		},
+		{
+			ID:    "StackSys",
+			MType: "gauge",
+			Value: &StackSys,
+		},
+		{
+			ID:    "Sys",
+			MType: "gauge",
+			Value: &Sys,
+		},
+		{
+			ID:    "TotalAlloc",
+			MType: "gauge",
+			Value: &TotalAlloc,
+		},
+		{
+			ID:    "PollCount",
+			MType: "counter",
+			Delta: &PollCount,
+		},
+		{
+			ID:    "RandomValue",
+			MType: "gauge",
+			Value: &RandomValue,
+		},
+	}
+	return metr, nil
+}
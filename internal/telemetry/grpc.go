@@ -0,0 +1,81 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/metricspb"
+)
+
+// grpcChunk is number of metrics in one stream message
+const grpcChunk = 100
+
+// SendMetricsGRPC sends batch in one UpdateMetrics stream, HMAC of all messages goes to metadata
+func (t *Telemetry) SendMetricsGRPC(metr []metrics.Metrics) error {
+	t.log.Info(fmt.Sprintf("sending metrics to grpc %s", t.grpcAddress))
+
+	requests := make([]*metricspb.UpdateMetricsRequest, 0, len(metr)/grpcChunk+1)
+	for start := 0; start < len(metr); start += grpcChunk {
+		end := start + grpcChunk
+		if end > len(metr) {
+			end = len(metr)
+		}
+		req := &metricspb.UpdateMetricsRequest{Metrics: make([]*metricspb.Metric, 0, end-start)}
+		for _, m := range metr[start:end] {
+			req.Metrics = append(req.Metrics, metricspb.FromMetric(m))
+		}
+		requests = append(requests, req)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if t.key != "" {
+		signer := metricspb.NewSigner(t.key)
+		for _, req := range requests {
+			if err := signer.Add(req); err != nil {
+				return err
+			}
+		}
+		hash := signer.Sum()
+		t.log.Infof("Calculated sha256 hash: %s", hash)
+		ctx = metadata.AppendToOutgoingContext(ctx, metricspb.HashKey, hash)
+	}
+
+	stream, err := t.grpcClient.UpdateMetrics(ctx)
+	if err != nil {
+		t.log.Errorf("Sending error: %s", err)
+		return err
+	}
+	for _, req := range requests {
+		if err := stream.Send(req); err != nil {
+			t.log.Errorf("Sending error: %s", err)
+			// real error comes from CloseAndRecv
+			break
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.log.Errorf("Sending error: %s", err)
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.FailedPrecondition, codes.AlreadyExists, codes.PermissionDenied, codes.Unauthenticated:
+			return fmt.Errorf("%w: %s", errRejected, err)
+		}
+		return err
+	}
+
+	t.log.Infof("grpc server stored %d metrics", resp.GetStored())
+	return nil
+}
+
+func dialGRPC(address string) (*grpc.ClientConn, error) {
+	return grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
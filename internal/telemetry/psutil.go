@@ -0,0 +1,141 @@
+package telemetry
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// PsutilCollector collects host metrics with gopsutil: memory, swap, per core cpu utilization,
+// load averages, disk usage per mount and disk and network io per device.
+// Unavailable sources are skipped
+type PsutilCollector struct {
+	mu sync.Mutex
+	// prev keeps last values of system cumulative counters to report deltas
+	prev map[string]uint64
+}
+
+func NewPsutilCollector() *PsutilCollector {
+	return &PsutilCollector{
+		prev: make(map[string]uint64),
+	}
+}
+
+func (c *PsutilCollector) Name() string {
+	return "psutil"
+}
+
+func (c *PsutilCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metr := []metrics.Metrics{}
+
+	if vm, err := mem.VirtualMemoryWithContext(ctx); err == nil {
+		metr = append(metr,
+			gaugeMetric("TotalMemory", float64(vm.Total), nil),
+			gaugeMetric("FreeMemory", float64(vm.Free), nil),
+		)
+	}
+
+	if swap, err := mem.SwapMemoryWithContext(ctx); err == nil {
+		metr = append(metr,
+			gaugeMetric("SwapTotal", float64(swap.Total), nil),
+			gaugeMetric("SwapUsed", float64(swap.Used), nil),
+			gaugeMetric("SwapFree", float64(swap.Free), nil),
+		)
+	}
+
+	if cpuCount, err := cpu.CountsWithContext(ctx, true); err == nil {
+		metr = append(metr, gaugeMetric("CPUCount", float64(cpuCount), nil))
+	}
+
+	// with zero interval utilization is measured since previous call
+	if percents, err := cpu.PercentWithContext(ctx, 0, true); err == nil {
+		for i, percent := range percents {
+			metr = append(metr, gaugeMetric("CPUutilization"+strconv.Itoa(i+1), percent, nil))
+		}
+	}
+
+	if avg, err := load.AvgWithContext(ctx); err == nil {
+		metr = append(metr,
+			gaugeMetric("Load1", avg.Load1, nil),
+			gaugeMetric("Load5", avg.Load5, nil),
+			gaugeMetric("Load15", avg.Load15, nil),
+		)
+	}
+
+	if partitions, err := disk.PartitionsWithContext(ctx, false); err == nil {
+		for _, p := range partitions {
+			usage, err := disk.UsageWithContext(ctx, p.Mountpoint)
+			if err != nil {
+				continue
+			}
+			labels := map[string]string{"mount": p.Mountpoint}
+			metr = append(metr,
+				gaugeMetric("DiskTotal", float64(usage.Total), labels),
+				gaugeMetric("DiskUsed", float64(usage.Used), labels),
+				gaugeMetric("DiskFree", float64(usage.Free), labels),
+			)
+		}
+	}
+
+	if ioCounters, err := disk.IOCountersWithContext(ctx); err == nil {
+		for device, io := range ioCounters {
+			labels := map[string]string{"device": device}
+			metr = c.appendCounter(metr, "DiskReadBytes", io.ReadBytes, labels)
+			metr = c.appendCounter(metr, "DiskWriteBytes", io.WriteBytes, labels)
+			metr = c.appendCounter(metr, "DiskReads", io.ReadCount, labels)
+			metr = c.appendCounter(metr, "DiskWrites", io.WriteCount, labels)
+		}
+	}
+
+	if netCounters, err := net.IOCountersWithContext(ctx, true); err == nil {
+		for _, io := range netCounters {
+			labels := map[string]string{"interface": io.Name}
+			metr = c.appendCounter(metr, "NetBytesSent", io.BytesSent, labels)
+			metr = c.appendCounter(metr, "NetBytesRecv", io.BytesRecv, labels)
+			metr = c.appendCounter(metr, "NetPacketsSent", io.PacketsSent, labels)
+			metr = c.appendCounter(metr, "NetPacketsRecv", io.PacketsRecv, labels)
+		}
+	}
+
+	return metr, nil
+}
+
+// appendCounter converts cumulative system counter to delta since previous poll.
+// First observation is only remembered, decreased counter is treated as restarted from zero
+func (c *PsutilCollector) appendCounter(metr []metrics.Metrics, id string, total uint64, labels map[string]string) []metrics.Metrics {
+	m := metrics.Metrics{ID: id, MType: "counter", Labels: labels}
+	key := m.Key()
+
+	prev, ok := c.prev[key]
+	c.prev[key] = total
+	if !ok {
+		return metr
+	}
+
+	delta := int64(total - prev)
+	if total < prev {
+		delta = int64(total)
+	}
+	m.Delta = &delta
+	return append(metr, m)
+}
+
+func gaugeMetric(id string, value float64, labels map[string]string) metrics.Metrics {
+	return metrics.Metrics{
+		ID:     id,
+		MType:  "gauge",
+		Value:  &value,
+		Labels: labels,
+	}
+}
@@ -0,0 +1,227 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Mr-Punder/go-alerting-service/internal/logger"
+	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+)
+
+// Spool is a bounded disk queue of batches that failed to be sent.
+// Every batch is a file named by sequence number, so directory order is queue order
+type Spool struct {
+	dir      string
+	maxBytes int64
+	log      logger.Logger
+
+	mu       sync.Mutex
+	replay   sync.Mutex
+	seq      uint64
+	dropped  int64
+	rejected int64
+}
+
+// spooledBatch is content of spool file, Key is idempotency key the batch is sent with on every attempt
+type spooledBatch struct {
+	Key   string            `json:"key"`
+	Batch []metrics.Metrics `json:"batch"`
+}
+
+func NewSpool(dir string, maxBytes int64, log logger.Logger) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		log:      log,
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		last := files[len(files)-1]
+		s.seq, _ = strconv.ParseUint(strings.TrimSuffix(last.Name(), ".json"), 10, 64)
+		log.Infof("Found %d spooled batches in %s", len(files), dir)
+	}
+	return s, nil
+}
+
+// Push stores batch with its idempotency key at the end of queue and drops oldest batches above size cap
+func (s *Spool) Push(key string, batch []metrics.Metrics) error {
+	data, err := json.Marshal(spooledBatch{Key: key, Batch: batch})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d.json", s.seq))
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	for i := 0; size > s.maxBytes && i < len(files); i++ {
+		if err := os.Remove(filepath.Join(s.dir, files[i].Name())); err != nil {
+			return err
+		}
+		size -= files[i].Size()
+		s.dropped++
+		s.log.Errorf("Spool is full, dropped batch %s", files[i].Name())
+	}
+	return nil
+}
+
+// Replay sends spooled batches oldest first with their keys and removes sent ones.
+// Batch rejected by server is removed too, so it doesn't block the queue.
+// Replay stops on any other error, the batch stays in spool
+func (s *Spool) Replay(send func(key string, batch []metrics.Metrics) error) error {
+	// one replay at a time keeps batches in order
+	if !s.replay.TryLock() {
+		return nil
+	}
+	defer s.replay.Unlock()
+
+	s.mu.Lock()
+	files, err := s.files()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		name := filepath.Join(s.dir, f.Name())
+		data, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			// dropped by Push meanwhile
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var spooled spooledBatch
+		if len(data) > 0 && data[0] == '[' {
+			// batch spooled by older agent is a plain array without key
+			err = json.Unmarshal(data, &spooled.Batch)
+		} else {
+			err = json.Unmarshal(data, &spooled)
+		}
+		if err != nil {
+			s.log.Errorf("Removing broken spooled batch %s: %s", f.Name(), err)
+			os.Remove(name)
+			continue
+		}
+		err = send(spooled.Key, spooled.Batch)
+		if errors.Is(err, errRejected) {
+			s.log.Errorf("Removing rejected spooled batch %s: %s", f.Name(), err)
+			s.countRejected()
+		} else if err != nil {
+			return err
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.log.Infof("Replayed spooled batch %s", f.Name())
+	}
+	return nil
+}
+
+// countRejected counts batch dropped because server rejected it
+func (s *Spool) countRejected() {
+	s.mu.Lock()
+	s.rejected++
+	s.mu.Unlock()
+}
+
+// Empty tells whether spool has no batches
+func (s *Spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, err := s.files()
+	return err == nil && len(files) == 0
+}
+
+// files returns spooled batches sorted from oldest
+func (s *Spool) files() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+func (s *Spool) Name() string {
+	return "spool"
+}
+
+// Collect reports spool state as agent self-metrics: batches dropped by size cap and rejected by server
+// since last poll and queue length
+func (s *Spool) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	s.mu.Lock()
+	dropped, rejected := s.dropped, s.rejected
+	s.dropped, s.rejected = 0, 0
+	files, err := s.files()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	queued := float64(len(files))
+	return []metrics.Metrics{
+		{
+			ID:    "SpoolDropped",
+			MType: "counter",
+			Delta: &dropped,
+		},
+		{
+			ID:    "SpoolRejected",
+			MType: "counter",
+			Delta: &rejected,
+		},
+		{
+			ID:    "SpoolBatches",
+			MType: "gauge",
+			Value: &queued,
+		},
+	}, nil
+}
@@ -5,64 +5,106 @@ import (
 	"compress/gzip"
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
-	"runtime"
-	"sync"
 
 	"strings"
 	"time"
 
-	"github.com/shirou/gopsutil/cpu"
-	"github.com/shirou/gopsutil/mem"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
 
+	"github.com/Mr-Punder/go-alerting-service/internal/agent/config"
 	"github.com/Mr-Punder/go-alerting-service/internal/logger"
 	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/metricspb"
 )
 
+// errRejected marks batch the server refused for good, sending it again gets the same answer
+var errRejected = errors.New("batch is rejected")
+
 type Telemetry struct {
-	log       logger.Logger
-	address   string
-	key       string
-	rateLimit int
+	log        logger.Logger
+	address    string
+	key        string
+	rateLimit  int
+	collectors *Registry
+	buffer     *Buffer
+	spool      *Spool
+
+	// send is SendMetrics or SendMetricsGRPC, key is idempotency key of batch
+	send        func(key string, batch []metrics.Metrics) error
+	grpcAddress string
+	grpcConn    *grpc.ClientConn
+	grpcClient  metricspb.MetricsClient
 }
 
-func NewTelemetry(adr string, key string, rateLimit int, logger logger.Logger) *Telemetry {
-	return &Telemetry{
-		log:       logger,
-		address:   adr,
-		key:       key,
-		rateLimit: rateLimit,
+func NewTelemetry(conf config.Config, collectors *Registry, logger logger.Logger) (*Telemetry, error) {
+	buffer, err := NewBuffer(conf.GaugeAggregation)
+	if err != nil {
+		return nil, err
+	}
+
+	var spool *Spool
+	if conf.SpoolDir != "" {
+		spool, err = NewSpool(conf.SpoolDir, conf.SpoolSize, logger)
+		if err != nil {
+			return nil, err
+		}
+		// spool self-metrics are reported whatever collectors are enabled
+		if err := collectors.registerSelf(spool); err != nil {
+			return nil, err
+		}
+	}
+
+	t := &Telemetry{
+		log:         logger,
+		address:     conf.ServerAddress,
+		key:         conf.HashKey,
+		rateLimit:   conf.RateLimit,
+		collectors:  collectors,
+		buffer:      buffer,
+		spool:       spool,
+		grpcAddress: conf.GRPCAddress,
+	}
+	t.send = t.SendMetrics
+	if conf.GRPCAddress != "" {
+		t.grpcConn, err = dialGRPC(conf.GRPCAddress)
+		if err != nil {
+			return nil, err
+		}
+		t.grpcClient = metricspb.NewMetricsClient(t.grpcConn)
+		// grpc server doesn't take idempotency keys
+		t.send = func(_ string, batch []metrics.Metrics) error {
+			return t.SendMetricsGRPC(batch)
+		}
 	}
+	return t, nil
 
 }
 
 func (t *Telemetry) Run(pollInt, repInt time.Duration) error {
 
-	// pollTicker := time.NewTicker(pollInt)
-	// reportTicker := time.NewTicker(repInt)
-	// defer pollTicker.Stop()
-	// defer reportTicker.Stop()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	if t.grpcConn != nil {
+		defer t.grpcConn.Close()
+	}
 
-	metricschan1 := t.CollectMetrics(ctx, pollInt)
-	metricschan2 := t.CollectPsutilMetrics(ctx, pollInt)
-	metrChanel := t.fanIn(ctx, metricschan1, metricschan2)
+	metrChanel := t.report(ctx, t.poll(ctx, pollInt), repInt)
 	t.log.Infof("Chanel created")
 
 	worker := func(ctx context.Context, metricChan <-chan []metrics.Metrics) error {
 
 		for metrics := range metricChan {
 			t.log.Info("I'm working")
-			err := t.SendMetrics(metrics)
-			if err != nil {
+			if err := t.deliver(metrics); err != nil {
 				t.log.Errorf("Error sending metrics")
 				return err
 			}
@@ -95,35 +137,45 @@ func (t *Telemetry) Run(pollInt, repInt time.Duration) error {
 	return nil
 }
 
-func (t *Telemetry) fanIn(ctx context.Context, metrChns ...chan []metrics.Metrics) chan []metrics.Metrics {
-	metricCh := make(chan []metrics.Metrics, t.rateLimit)
-	var wg sync.WaitGroup
-	wg.Add(len(metrChns))
-	for _, ch := range metrChns {
-		metCh := ch
-		go func() {
-			defer wg.Done()
-
-			for m := range metCh {
-				select {
-				case <-ctx.Done():
-					return
-				case metricCh <- m:
-				}
-
-			}
-		}()
+// deliver sends batch. With spool batch goes after spooled ones, so server gets batches in order,
+// and failed batch is spooled instead of being returned as error. Batch rejected by server is dropped.
+// Batch keeps one idempotency key through retries and replays, so server stores it once
+func (t *Telemetry) deliver(batch []metrics.Metrics) error {
+	key, err := idempotencyKey()
+	if err != nil {
+		return err
+	}
+	if t.spool == nil {
+		return t.send(key, batch)
+	}
 
+	if !t.spool.Empty() {
+		if err := t.spool.Push(key, batch); err != nil {
+			t.log.Errorf("Error spooling metrics %s", err)
+		}
+		if err := t.spool.Replay(t.send); err != nil {
+			t.log.Errorf("Error replaying spooled metrics %s", err)
+		}
+		return nil
 	}
 
-	go func() {
-		wg.Wait()
-		close(metricCh)
-	}()
-	return metricCh
+	err = t.send(key, batch)
+	if errors.Is(err, errRejected) {
+		t.log.Errorf("Batch is dropped: %s", err)
+		t.spool.countRejected()
+		return nil
+	}
+	if err != nil {
+		t.log.Errorf("Error sending metrics, batch is spooled: %s", err)
+		if err := t.spool.Push(key, batch); err != nil {
+			t.log.Errorf("Error spooling metrics %s", err)
+		}
+	}
+	return nil
 }
 
-func (t *Telemetry) CollectPsutilMetrics(ctx context.Context, pollInt time.Duration) chan []metrics.Metrics {
+// poll collects metrics from enabled collectors every pollInt, one batch per collector
+func (t *Telemetry) poll(ctx context.Context, pollInt time.Duration) chan []metrics.Metrics {
 	metricsch := make(chan []metrics.Metrics, t.rateLimit+1)
 
 	pollTicker := time.NewTicker(pollInt)
@@ -135,243 +187,60 @@ func (t *Telemetry) CollectPsutilMetrics(ctx context.Context, pollInt time.Durat
 			case <-ctx.Done():
 				return
 			case <-pollTicker.C:
-				metr := []metrics.Metrics{}
-				vm, err := mem.VirtualMemory()
-				if err == nil {
-					totalMemory := float64(vm.Total)
-					freeMemory := float64(vm.Free)
-					metr = append(metr, metrics.Metrics{
-						ID:    "TotalMemory",
-						MType: "gauge",
-						Value: &totalMemory,
-					})
-					metr = append(metr, metrics.Metrics{
-						ID:    "FreeMemory",
-						MType: "gauge",
-						Value: &freeMemory,
-					})
-				}
-
-				cpuInfo, err := cpu.Info()
-				if err == nil && len(cpuInfo) > 0 {
-					cpuCount := float64(len(cpuInfo))
-
-					metr = append(metr, metrics.Metrics{
-						ID:    "CPUCount",
-						MType: "gauge",
-						Value: &cpuCount,
-					})
+				for _, c := range t.collectors.Collectors() {
+					metr, err := c.Collect(ctx)
+					if err != nil {
+						t.log.Errorf("Collector %s error: %s", c.Name(), err)
+						continue
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case metricsch <- metr:
+					}
 				}
-				metricsch <- metr
-
 			}
 		}
 	}()
 	return metricsch
 }
 
-// Collect returns slice of current runtime metrics
-func (t *Telemetry) CollectMetrics(ctx context.Context, pollInt time.Duration) chan []metrics.Metrics {
-	metricsch := make(chan []metrics.Metrics, t.rateLimit+1)
+// report accumulates polled batches in buffer and flushes one batch every repInt
+func (t *Telemetry) report(ctx context.Context, polled <-chan []metrics.Metrics, repInt time.Duration) chan []metrics.Metrics {
+	reportch := make(chan []metrics.Metrics, t.rateLimit)
 
-	pollTicker := time.NewTicker(pollInt)
+	reportTicker := time.NewTicker(repInt)
 	go func() {
-		defer close(metricsch)
-		defer pollTicker.Stop()
+		defer close(reportch)
+		defer reportTicker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-pollTicker.C:
-				memStats := runtime.MemStats{}
-				runtime.ReadMemStats(&memStats)
-
-				Alloc := float64(memStats.Alloc)
-				BuckHashSys := float64(memStats.BuckHashSys)
-				Frees := float64(memStats.Frees)
-				GCCPUFraction := memStats.GCCPUFraction
-				GCSys := float64(memStats.GCSys)
-				HeapAlloc := float64(memStats.HeapAlloc)
-				HeapIdle := float64(memStats.HeapIdle)
-				HeapInuse := float64(memStats.HeapInuse)
-				HeapObjects := float64(memStats.HeapObjects)
-				HeapReleased := float64(memStats.HeapReleased)
-				HeapSys := float64(memStats.HeapSys)
-				LastGC := float64(memStats.LastGC)
-				Lookups := float64(memStats.Lookups)
-				MCacheInuse := float64(memStats.MCacheInuse)
-				MCacheSys := float64(memStats.MCacheSys)
-				MSpanInuse := float64(memStats.MSpanInuse)
-				MSpanSys := float64(memStats.MSpanSys)
-				Mallocs := float64(memStats.Mallocs)
-				NextGC := float64(memStats.NextGC)
-				NumForcedGC := float64(memStats.NumForcedGC)
-				NumGC := float64(uint64(memStats.NumGC))
-				OtherSys := float64(memStats.OtherSys)
-				PauseTotalNs := float64(memStats.PauseTotalNs)
-				StackInuse := float64(memStats.StackInuse)
-				StackSys := float64(memStats.StackSys)
-				Sys := float64(memStats.Sys)
-				TotalAlloc := float64(memStats.TotalAlloc)
-				var PollCount int64 = 1
-				RandomValue := rand.Float64()
-
-				metr := []metrics.Metrics{
-					{
-						ID:    "Alloc",
-						MType: "gauge",
-						Value: &Alloc,
-					},
-					{
-						ID:    "BuckHashSys",
-						MType: "gauge",
-						Value: &BuckHashSys,
-					},
-					{
-						ID:    "Frees",
-						MType: "gauge",
-						Value: &Frees,
-					},
-					{
-						ID:    "GCCPUFraction",
-						MType: "gauge",
-						Value: &GCCPUFraction,
-					},
-					{
-						ID:    "GCSys",
-						MType: "gauge",
-						Value: &GCSys,
-					},
-					{
-						ID:    "HeapAlloc",
-						MType: "gauge",
-						Value: &HeapAlloc,
-					},
-					{
-						ID:    "HeapIdle",
-						MType: "gauge",
-						Value: &HeapIdle,
-					},
-					{
-						ID:    "HeapInuse",
-						MType: "gauge",
-						Value: &HeapInuse,
-					},
-					{
-						ID:    "HeapObjects",
-						MType: "gauge",
-						Value: &HeapObjects,
-					},
-					{
-						ID:    "HeapReleased",
-						MType: "gauge",
-						Value: &HeapReleased,
-					},
-					{
-						ID:    "HeapSys",
-						MType: "gauge",
-						Value: &HeapSys,
-					},
-					{
-						ID:    "LastGC",
-						MType: "gauge",
-						Value: &LastGC,
-					},
-					{
-						ID:    "Lookups",
-						MType: "gauge",
-						Value: &Lookups,
-					},
-					{
-						ID:    "MCacheInuse",
-						MType: "gauge",
-						Value: &MCacheInuse,
-					},
-					{
-						ID:    "MCacheSys",
-						MType: "gauge",
-						Value: &MCacheSys,
-					},
-					{
-						ID:    "MSpanInuse",
-						MType: "gauge",
-						Value: &MSpanInuse,
-					},
-					{
-						ID:    "MSpanSys",
-						MType: "gauge",
-						Value: &MSpanSys,
-					},
-					{
-						ID:    "Mallocs",
-						MType: "gauge",
-						Value: &Mallocs,
-					},
-					{
-						ID:    "NextGC",
-						MType: "gauge",
-						Value: &NextGC,
-					},
-					{
-						ID:    "NumForcedGC",
-						MType: "gauge",
-						Value: &NumForcedGC,
-					},
-					{
-						ID:    "NumGC",
-						MType: "gauge",
-						Value: &NumGC,
-					},
-					{
-						ID:    "OtherSys",
-						MType: "gauge",
-						Value: &OtherSys,
-					},
-					{
-						ID:    "PauseTotalNs",
-						MType: "gauge",
-						Value: &PauseTotalNs,
-					},
-					{
-						ID:    "StackInuse",
-						MType: "gauge",
-						Value: &StackInuse,
-					},
-					{
-						ID:    "StackSys",
-						MType: "gauge",
-						Value: &StackSys,
-					},
-					{
-						ID:    "Sys",
-						MType: "gauge",
-						Value: &Sys,
-					},
-					{
-						ID:    "TotalAlloc",
-						MType: "gauge",
-						Value: &TotalAlloc,
-					},
-					{
-						ID:    "PollCount",
-						MType: "counter",
-						Delta: &PollCount,
-					},
-					{
-						ID:    "RandomValue",
-						MType: "gauge",
-						Value: &RandomValue,
-					},
+			case batch, ok := <-polled:
+				if !ok {
+					return
+				}
+				t.buffer.Add(batch)
+			case <-reportTicker.C:
+				batch := t.buffer.Flush()
+				if len(batch) == 0 {
+					continue
+				}
+				t.log.Infof("Reporting %d metrics", len(batch))
+				select {
+				case <-ctx.Done():
+					return
+				case reportch <- batch:
 				}
-				metricsch <- metr
-
 			}
 		}
 	}()
-	return metricsch
+	return reportch
 }
 
-func (t *Telemetry) SendMetrics(metr []metrics.Metrics) error {
+// SendMetrics sends batch to /updates/ with idempotency key, batch without key may be stored twice on retry
+func (t *Telemetry) SendMetrics(key string, metr []metrics.Metrics) error {
 	address := "http://" + t.address
 	t.log.Info(fmt.Sprintf("sending metrics to %s", address))
 
@@ -413,6 +282,10 @@ func (t *Telemetry) SendMetrics(metr []metrics.Metrics) error {
 		req.Header.Set("HashSHA256", hashStr)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	// the same key on every attempt makes server store batch once even if first attempt reached it
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
 	req.Header.Set("Accept-Encoding", "gzip")
 	req.Header.Set("Content-Encoding", "gzip")
 	resp, err := client.Do(req)
@@ -431,6 +304,9 @@ func (t *Telemetry) SendMetrics(metr []metrics.Metrics) error {
 				return err
 			}
 			req.Header.Set("Content-Type", "application/json")
+			if key != "" {
+				req.Header.Set("Idempotency-Key", key)
+			}
 			req.Header.Del("Accept-Encoding")
 			resp, err = client.Do(req)
 			t.log.Info(fmt.Sprintf("Repeated request, err: %s", err))
@@ -449,6 +325,12 @@ func (t *Telemetry) SendMetrics(metr []metrics.Metrics) error {
 	if err != nil {
 		t.log.Errorf("Sending error: %s", err)
 
+		return err
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		t.log.Error(fmt.Sprintf("Batch is rejected with code %d", resp.StatusCode))
+
+		return fmt.Errorf("%w: status code %d", errRejected, resp.StatusCode)
 	}
 	if resp.StatusCode != http.StatusOK {
 		t.log.Error(fmt.Sprintf("Unexpected code %d", resp.StatusCode))
@@ -479,3 +361,12 @@ func (t *Telemetry) SendMetrics(metr []metrics.Metrics) error {
 
 	return nil
 }
+
+// idempotencyKey returns random key of one batch
+func idempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
@@ -1,13 +1,30 @@
 package telemetry
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/Mr-Punder/go-alerting-service/internal/agent/config"
+	"github.com/Mr-Punder/go-alerting-service/internal/grpcserver"
+	"github.com/Mr-Punder/go-alerting-service/internal/handlers"
 	"github.com/Mr-Punder/go-alerting-service/internal/logger"
 	"github.com/Mr-Punder/go-alerting-service/internal/metrics"
+	"github.com/Mr-Punder/go-alerting-service/internal/middleware"
+	"github.com/Mr-Punder/go-alerting-service/internal/storage"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -60,3 +77,384 @@ func TestSendMetrics(t *testing.T) {
 		})
 	}
 }
+
+type testCollector struct {
+	name string
+}
+
+func (c *testCollector) Name() string {
+	return c.name
+}
+
+func (c *testCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	value := 1.0
+	return []metrics.Metrics{{ID: c.name, MType: "gauge", Value: &value}}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	reg := NewRegistry()
+	require.NoError(t, reg.Register(&testCollector{name: "first"}))
+	require.NoError(t, reg.Register(&testCollector{name: "second"}))
+	require.Error(t, reg.Register(&testCollector{name: "first"}))
+
+	tests := []struct {
+		name    string
+		enabled []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "all enabled by default",
+			want: []string{"first", "second"},
+		},
+		{
+			name:    "only second",
+			enabled: []string{"second"},
+			want:    []string{"second"},
+		},
+		{
+			name:    "unknown keeps enabled",
+			enabled: []string{"second", "unknown"},
+			want:    []string{"second"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := reg.Enable(tt.enabled...)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			tel, err := NewTelemetry(config.Config{RateLimit: 1, GaugeAggregation: GaugeLast}, reg, log)
+			require.NoError(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch := tel.poll(ctx, 10*time.Millisecond)
+
+			got := make([]string, 0)
+			for range tt.want {
+				batch := <-ch
+				require.Len(t, batch, 1)
+				got = append(got, batch[0].ID)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	gauge := func(id string, v float64) metrics.Metrics {
+		return metrics.Metrics{ID: id, MType: "gauge", Value: &v}
+	}
+	counter := func(id string, d int64) metrics.Metrics {
+		return metrics.Metrics{ID: id, MType: "counter", Delta: &d}
+	}
+	polls := [][]metrics.Metrics{
+		{gauge("Alloc", 4), counter("PollCount", 1)},
+		{gauge("Alloc", 1), counter("PollCount", 1)},
+		{gauge("Alloc", 7), counter("PollCount", 1)},
+	}
+
+	tests := []struct {
+		mode      string
+		wantGauge float64
+	}{
+		{mode: GaugeLast, wantGauge: 7},
+		{mode: GaugeMin, wantGauge: 1},
+		{mode: GaugeMax, wantGauge: 7},
+		{mode: GaugeAvg, wantGauge: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			buf, err := NewBuffer(tt.mode)
+			require.NoError(t, err)
+			for _, batch := range polls {
+				buf.Add(batch)
+			}
+
+			flushed := buf.Flush()
+			require.Len(t, flushed, 2)
+			assert.Equal(t, "Alloc", flushed[0].ID)
+			assert.Equal(t, tt.wantGauge, *flushed[0].Value)
+			assert.Equal(t, "PollCount", flushed[1].ID)
+			assert.Equal(t, int64(3), *flushed[1].Delta)
+
+			assert.Empty(t, buf.Flush())
+		})
+	}
+
+	_, err := NewBuffer("median")
+	assert.Error(t, err)
+}
+
+func TestSpool(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	batch := func(id string) []metrics.Metrics {
+		value := 1.0
+		return []metrics.Metrics{{ID: id, MType: "gauge", Value: &value}}
+	}
+	size := int64(len(`{"key":"kb1","batch":[{"id":"b1","type":"gauge","value":1}]}`))
+
+	dir := t.TempDir()
+	spool, err := NewSpool(dir, 2*size, log)
+	require.NoError(t, err)
+
+	for _, id := range []string{"b1", "b2", "b3"} {
+		require.NoError(t, spool.Push("k"+id, batch(id)))
+	}
+
+	self, err := spool.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *self[0].Delta)
+	assert.Equal(t, 2.0, *self[2].Value)
+
+	errDown := errors.New("server is down")
+	require.ErrorIs(t, spool.Replay(func(string, []metrics.Metrics) error { return errDown }), errDown)
+
+	// reopened spool continues the sequence
+	spool, err = NewSpool(dir, 2*size, log)
+	require.NoError(t, err)
+	require.NoError(t, spool.Push("kb4", batch("b4")))
+
+	// batch spooled by older agent has no key
+	require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("%020d.json", 5)), []byte(`[{"id":"b5","type":"gauge","value":1}]`), 0666))
+
+	sent := make([]string, 0)
+	keys := make([]string, 0)
+	require.NoError(t, spool.Replay(func(key string, m []metrics.Metrics) error {
+		sent = append(sent, m[0].ID)
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Equal(t, []string{"b3", "b4", "b5"}, sent)
+	assert.Equal(t, []string{"kb3", "kb4", ""}, keys)
+
+	self, err = spool.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *self[0].Delta)
+	assert.Equal(t, 0.0, *self[2].Value)
+}
+
+func TestPsutilCounterDelta(t *testing.T) {
+	c := NewPsutilCollector()
+	labels := map[string]string{"interface": "eth0"}
+
+	tests := []struct {
+		name      string
+		total     uint64
+		wantDelta *int64
+	}{
+		{name: "first observation is baseline", total: 100},
+		{name: "growth", total: 150, wantDelta: func() *int64 { var d int64 = 50; return &d }()},
+		{name: "no change", total: 150, wantDelta: func() *int64 { var d int64; return &d }()},
+		{name: "reset", total: 20, wantDelta: func() *int64 { var d int64 = 20; return &d }()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metr := c.appendCounter(nil, "NetBytesRecv", tt.total, labels)
+			if tt.wantDelta == nil {
+				assert.Empty(t, metr)
+				return
+			}
+			require.Len(t, metr, 1)
+			assert.Equal(t, "counter", metr[0].MType)
+			assert.Equal(t, *tt.wantDelta, *metr[0].Delta)
+		})
+	}
+}
+
+func TestSendMetricsGRPC(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", log)
+	require.NoError(t, err)
+
+	srv, err := grpcserver.NewServer("127.0.0.1:0", stor, "secret", log)
+	require.NoError(t, err)
+	go srv.RunServer()
+	defer srv.Shutdown(context.Background())
+
+	tel, err := NewTelemetry(config.Config{
+		RateLimit:        1,
+		GaugeAggregation: GaugeLast,
+		HashKey:          "secret",
+		GRPCAddress:      srv.Addr().String(),
+	}, NewRegistry(), log)
+	require.NoError(t, err)
+	defer tel.grpcConn.Close()
+
+	// more than one stream message
+	batch := make([]metrics.Metrics, 0, grpcChunk+1)
+	for i := 0; i <= grpcChunk; i++ {
+		delta := int64(i)
+		batch = append(batch, metrics.Metrics{ID: fmt.Sprintf("c%d", i), MType: "counter", Delta: &delta})
+	}
+	require.NoError(t, tel.send("", batch))
+	assert.Len(t, stor.GetAll(context.Background()), grpcChunk+1)
+
+	tel.key = "wrong"
+	assert.Error(t, tel.send("", batch))
+}
+
+func TestDeliverInOrder(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	reg := NewRegistry()
+	require.NoError(t, reg.Register(&testCollector{name: "first"}))
+	require.NoError(t, reg.Enable("first"))
+
+	tel, err := NewTelemetry(config.Config{RateLimit: 1, GaugeAggregation: GaugeLast, SpoolDir: t.TempDir(), SpoolSize: 1 << 20}, reg, log)
+	require.NoError(t, err)
+
+	// spool self-metrics stay enabled
+	names := make([]string, 0)
+	for _, c := range reg.Collectors() {
+		names = append(names, c.Name())
+	}
+	assert.Equal(t, []string{"first", "spool"}, names)
+
+	batch := func(id string) []metrics.Metrics {
+		value := 1.0
+		return []metrics.Metrics{{ID: id, MType: "gauge", Value: &value}}
+	}
+	down := true
+	sent := make([]string, 0)
+	tel.send = func(key string, m []metrics.Metrics) error {
+		if down {
+			return errors.New("server is down")
+		}
+		sent = append(sent, m[0].ID)
+		return nil
+	}
+
+	require.NoError(t, tel.deliver(batch("b1")))
+	require.NoError(t, tel.deliver(batch("b2")))
+	down = false
+	require.NoError(t, tel.deliver(batch("b3")))
+	require.NoError(t, tel.deliver(batch("b4")))
+
+	assert.Equal(t, []string{"b1", "b2", "b3", "b4"}, sent)
+	assert.True(t, tel.spool.Empty())
+}
+
+func TestDeliverDroppedResponse(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	stor, err := storage.NewMemStorage(make(map[string]metrics.Metrics), false, "", log)
+	require.NoError(t, err)
+	stor.EnableIdempotency(time.Minute)
+	router := middleware.NewGzipCompressor(log).CompressHandler(handlers.NewMetricRouter(stor, nil, log))
+
+	// server stores batch while response is dropped, so agent sees an error
+	var drop atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if drop.Load() {
+			router.ServeHTTP(httptest.NewRecorder(), r)
+			panic(http.ErrAbortHandler)
+		}
+		router.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	tel, err := NewTelemetry(config.Config{
+		ServerAddress:    strings.TrimPrefix(server.URL, "http://"),
+		RateLimit:        1,
+		GaugeAggregation: GaugeLast,
+		SpoolDir:         t.TempDir(),
+		SpoolSize:        1 << 20,
+	}, NewRegistry(), log)
+	require.NoError(t, err)
+
+	counter := func(d int64) []metrics.Metrics {
+		return []metrics.Metrics{{ID: "c", MType: "counter", Delta: &d}}
+	}
+
+	drop.Store(true)
+	require.NoError(t, tel.deliver(counter(2)))
+	assert.False(t, tel.spool.Empty())
+
+	// replayed batch keeps its key and is not stored again
+	drop.Store(false)
+	require.NoError(t, tel.deliver(counter(3)))
+	assert.True(t, tel.spool.Empty())
+
+	c, ok := stor.Get(context.Background(), metrics.Metrics{ID: "c", MType: "counter"})
+	require.True(t, ok)
+	assert.Equal(t, int64(5), *c.Delta)
+}
+
+func TestSpoolRejectedHead(t *testing.T) {
+	log, err := logger.NewZapLogger("info", "stdout")
+	require.NoError(t, err)
+
+	// server is down first, then it rejects batch with wrong type and stores the rest
+	var up atomic.Bool
+	received := make([]string, 0)
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+			body = zr
+		}
+		var batch []metrics.Metrics
+		if !assert.NoError(t, json.NewDecoder(body).Decode(&batch)) {
+			return
+		}
+		if batch[0].MType != "gauge" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, batch[0].ID)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	tel, err := NewTelemetry(config.Config{
+		ServerAddress:    strings.TrimPrefix(server.URL, "http://"),
+		RateLimit:        1,
+		GaugeAggregation: GaugeLast,
+		SpoolDir:         t.TempDir(),
+		SpoolSize:        1 << 20,
+	}, NewRegistry(), log)
+	require.NoError(t, err)
+
+	value := 1.0
+	delta := int64(1)
+	require.NoError(t, tel.deliver([]metrics.Metrics{{ID: "bad", MType: "counter", Delta: &delta}}))
+	require.NoError(t, tel.deliver([]metrics.Metrics{{ID: "b1", MType: "gauge", Value: &value}}))
+	assert.False(t, tel.spool.Empty())
+
+	// rejected head is dropped and doesn't block batches behind it
+	up.Store(true)
+	require.NoError(t, tel.deliver([]metrics.Metrics{{ID: "b2", MType: "gauge", Value: &value}}))
+	assert.True(t, tel.spool.Empty())
+	assert.Equal(t, []string{"b1", "b2"}, received)
+
+	// rejected batch is not spooled
+	require.NoError(t, tel.deliver([]metrics.Metrics{{ID: "bad", MType: "counter", Delta: &delta}}))
+	assert.True(t, tel.spool.Empty())
+
+	self, err := tel.spool.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "SpoolRejected", self[1].ID)
+	assert.Equal(t, int64(2), *self[1].Delta)
+}